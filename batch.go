@@ -0,0 +1,112 @@
+package shardmap
+
+// Batch is a queue of Set and Delete operations that are applied to a Map
+// together, using a single lock acquisition per shard. Create one using
+// NewBatch. A Batch is not thread-safe.
+type Batch struct {
+	m   *Map
+	ops []batchOp
+}
+
+type batchOp struct {
+	key    string
+	value  interface{}
+	delete bool
+	shard  int
+}
+
+// BatchResult is the result of a single operation in a Batch.
+type BatchResult struct {
+	// Prev is the previous value, if any.
+	Prev interface{}
+	// OK is true when a value was replaced by Set, or deleted by Delete.
+	OK bool
+}
+
+// NewBatch returns a new empty batch for the map.
+func (m *Map) NewBatch() *Batch {
+	m.initDo()
+	return &Batch{m: m}
+}
+
+// Set queues the assignment of a value to a key.
+func (b *Batch) Set(key string, value interface{}) {
+	b.ops = append(b.ops, batchOp{key: key, value: value,
+		shard: b.m.choose(key)})
+}
+
+// Delete queues the deletion of a key.
+func (b *Batch) Delete(key string) {
+	b.ops = append(b.ops, batchOp{key: key, delete: true,
+		shard: b.m.choose(key)})
+}
+
+// Len returns the number of queued operations.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Reset removes all queued operations.
+func (b *Batch) Reset() {
+	for i := range b.ops {
+		b.ops[i] = batchOp{}
+	}
+	b.ops = b.ops[:0]
+}
+
+// Apply applies all queued operations and then resets the batch. Operations
+// are grouped by shard, and each shard is locked once for its whole group.
+// Operations on the same key are applied in the order that they were queued.
+// The batch as a whole is not atomic; other goroutines may observe some
+// shards before and others after their group has been applied.
+func (b *Batch) Apply() {
+	b.apply(nil)
+}
+
+// ApplyResults is like Apply, but also returns the result of each operation,
+// in the order that they were queued.
+func (b *Batch) ApplyResults() []BatchResult {
+	results := make([]BatchResult, len(b.ops))
+	b.apply(results)
+	return results
+}
+
+func (b *Batch) apply(results []BatchResult) {
+	m := b.m
+	// counting sort of the operation indexes by shard, which keeps the
+	// queued order within each shard
+	starts := make([]int, m.shards+1)
+	for _, op := range b.ops {
+		starts[op.shard+1]++
+	}
+	for i := 1; i < len(starts); i++ {
+		starts[i] += starts[i-1]
+	}
+	order := make([]int, len(b.ops))
+	next := append([]int{}, starts[:m.shards]...)
+	for i, op := range b.ops {
+		order[next[op.shard]] = i
+		next[op.shard]++
+	}
+	for shard := 0; shard < m.shards; shard++ {
+		group := order[starts[shard]:starts[shard+1]]
+		if len(group) == 0 {
+			continue
+		}
+		t := m.lock(shard)
+		for _, i := range group {
+			op := &b.ops[i]
+			var r BatchResult
+			if op.delete {
+				r.Prev, r.OK = m.delete(shard, op.key)
+			} else {
+				r.Prev, r.OK = m.set(shard, op.key, op.value)
+			}
+			if results != nil {
+				results[i] = r
+			}
+		}
+		m.unlock(shard, t)
+	}
+	b.Reset()
+}
@@ -0,0 +1,56 @@
+package shardmap
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	var m Map
+	m.Set("0", "old")
+	b := m.NewBatch()
+	for i := 0; i < 1000; i++ {
+		b.Set(fmt.Sprintf("%d", i), i)
+	}
+	b.Delete("1")
+	b.Delete("missing")
+	b.Set("1", "again")
+	if b.Len() != 1003 {
+		t.Fatalf("expected '%v', got '%v'", 1003, b.Len())
+	}
+	if m.Len() != 1 {
+		t.Fatal("expected nothing to be applied yet")
+	}
+	results := b.ApplyResults()
+	if b.Len() != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, b.Len())
+	}
+	if len(results) != 1003 {
+		t.Fatalf("expected '%v', got '%v'", 1003, len(results))
+	}
+	if !results[0].OK || results[0].Prev != "old" {
+		t.Fatalf("unexpected result %+v", results[0])
+	}
+	for i := 1; i < 1000; i++ {
+		if results[i].OK || results[i].Prev != nil {
+			t.Fatalf("unexpected result %+v", results[i])
+		}
+	}
+	if !results[1000].OK || results[1000].Prev != 1 {
+		t.Fatalf("unexpected result %+v", results[1000])
+	}
+	if results[1001].OK || results[1002].OK {
+		t.Fatal("unexpected results")
+	}
+	if m.Len() != 1000 {
+		t.Fatalf("expected '%v', got '%v'", 1000, m.Len())
+	}
+	if v, _ := m.Get("1"); v != "again" {
+		t.Fatalf("expected '%v', got '%v'", "again", v)
+	}
+	b.Delete("0")
+	b.Apply()
+	if m.Len() != 999 {
+		t.Fatalf("expected '%v', got '%v'", 999, m.Len())
+	}
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"math/rand"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	cmap "github.com/orcaman/concurrent-map"
-	"github.com/tidwall/lotsa"
 	"github.com/tidwall/shardmap"
 )
 
@@ -22,153 +27,294 @@ func randKey(rnd *rand.Rand, n int) string {
 	return string(s)
 }
 
-func main() {
+// target is a map that's being benchmarked.
+type target interface {
+	set(key string, value interface{})
+	get(key string) (interface{}, bool)
+	del(key string)
+	rng()
+}
 
-	seed := time.Now().UnixNano()
-	// println(seed)
-	rng := rand.New(rand.NewSource(seed))
-	N := 1_000_000
-	K := 10
+type syncMap struct{ m sync.Map }
 
-	fmt.Printf("\n")
-	fmt.Printf("go version %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
-	fmt.Printf("\n")
-	fmt.Printf("     number of cpus: %d\n", runtime.NumCPU())
-	fmt.Printf("     number of keys: %d\n", N)
-	fmt.Printf("            keysize: %d\n", K)
-	fmt.Printf("        random seed: %d\n", seed)
+func (t *syncMap) set(key string, value interface{})  { t.m.Store(key, value) }
+func (t *syncMap) get(key string) (interface{}, bool) { return t.m.Load(key) }
+func (t *syncMap) del(key string)                     { t.m.Delete(key) }
+func (t *syncMap) rng() {
+	t.m.Range(func(key, value interface{}) bool { return true })
+}
 
-	fmt.Printf("\n")
+type stdMap struct {
+	mu sync.RWMutex
+	m  map[string]interface{}
+}
 
-	keysm := make(map[string]bool, N)
-	for len(keysm) < N {
-		keysm[randKey(rng, K)] = true
+func (t *stdMap) set(key string, value interface{}) {
+	t.mu.Lock()
+	t.m[key] = value
+	t.mu.Unlock()
+}
+func (t *stdMap) get(key string) (interface{}, bool) {
+	t.mu.RLock()
+	v, ok := t.m[key]
+	t.mu.RUnlock()
+	return v, ok
+}
+func (t *stdMap) del(key string) {
+	t.mu.Lock()
+	delete(t.m, key)
+	t.mu.Unlock()
+}
+func (t *stdMap) rng() {
+	t.mu.RLock()
+	for _, v := range t.m {
+		if v == nil {
+			panic("bad news")
+		}
 	}
-	keys := make([]string, 0, N)
-	for key := range keysm {
-		keys = append(keys, key)
+	t.mu.RUnlock()
+}
+
+type concurrentMap struct{ m cmap.ConcurrentMap }
+
+func (t *concurrentMap) set(key string, value interface{})  { t.m.Set(key, value) }
+func (t *concurrentMap) get(key string) (interface{}, bool) { return t.m.Get(key) }
+func (t *concurrentMap) del(key string)                     { t.m.Remove(key) }
+func (t *concurrentMap) rng() {
+	for range t.m.IterBuffered() {
 	}
+}
+
+type shardMap struct{ m shardmap.Map }
 
-	lotsa.Output = os.Stdout
-	// lotsa.MemUsage = true
+func (t *shardMap) set(key string, value interface{})  { t.m.Set(key, value) }
+func (t *shardMap) get(key string) (interface{}, bool) { return t.m.Get(key) }
+func (t *shardMap) del(key string)                     { t.m.Delete(key) }
+func (t *shardMap) rng() {
+	t.m.Range(func(key string, value interface{}) bool { return true })
+}
 
-	var mu sync.RWMutex
+var targets = []struct {
+	name  string
+	title string
+	new   func() target
+}{
+	{"syncmap", "sync.Map", func() target { return new(syncMap) }},
+	{"stdmap", "stdlib map", func() target {
+		return &stdMap{m: make(map[string]interface{})}
+	}},
+	{"cmap", "github.com/orcaman/concurrent-map", func() target {
+		return &concurrentMap{m: cmap.New()}
+	}},
+	{"shardmap", "github.com/tidwall/shardmap", func() target {
+		return new(shardMap)
+	}},
+}
 
-	println("-- sync.Map --")
-	var sm sync.Map
-	print("set: ")
-	lotsa.Ops(N, runtime.NumCPU(), func(i, _ int) {
-		sm.Store(keys[i], i)
-	})
+// result is the outcome of one benchmark phase.
+type result struct {
+	Map        string  `json:"map"`
+	Op         string  `json:"op"`
+	Ops        int     `json:"ops"`
+	Goroutines int     `json:"goroutines"`
+	Seconds    float64 `json:"seconds"`
+	OpsPerSec  float64 `json:"ops_per_sec"`
+	NsPerOp    float64 `json:"ns_per_op"`
+}
 
-	print("get: ")
-	lotsa.Ops(N, runtime.NumCPU(), func(i, _ int) {
-		v, _ := sm.Load(keys[i])
-		if v.(int) != i {
-			panic("bad news")
-		}
-	})
-	print("rng:       ")
-	lotsa.Ops(100, runtime.NumCPU(), func(i, _ int) {
-		sm.Range(func(key, value interface{}) bool {
-			return true
-		})
-	})
-	print("del: ")
-	lotsa.Ops(N, runtime.NumCPU(), func(i, _ int) {
-		mu.Lock()
-		sm.Delete(keys[i])
-		mu.Unlock()
-	})
-	println()
-
-	println("-- stdlib map --")
-	m := make(map[string]interface{})
-	print("set: ")
-	lotsa.Ops(N, runtime.NumCPU(), func(i, _ int) {
-		mu.Lock()
-		m[keys[i]] = i
-		mu.Unlock()
-	})
-	print("get: ")
-	lotsa.Ops(N, runtime.NumCPU(), func(i, _ int) {
-		mu.RLock()
-		v := m[keys[i]]
-		mu.RUnlock()
-		if v.(int) != i {
-			panic("bad news")
-		}
-	})
-	print("rng:       ")
-	lotsa.Ops(100, runtime.NumCPU(), func(i, _ int) {
-		mu.RLock()
-		for _, v := range m {
-			if v == nil {
-				panic("bad news")
+func (r result) String() string {
+	return fmt.Sprintf("%s: %s ops over %d threads in %.0fms, %s/sec, %.0f ns/op",
+		r.Op, commas(r.Ops), r.Goroutines, r.Seconds*1000,
+		commas(int(r.OpsPerSec)), r.NsPerOp)
+}
+
+func commas(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// ops calls fn for every op in [0,n), spread over the goroutines.
+func ops(name, op string, n, procs int, fn func(i int)) result {
+	var next int64 = -1
+	var wg sync.WaitGroup
+	wg.Add(procs)
+	start := time.Now()
+	for i := 0; i < procs; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= n {
+					return
+				}
+				fn(i)
 			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	return result{
+		Map:        name,
+		Op:         op,
+		Ops:        n,
+		Goroutines: procs,
+		Seconds:    elapsed.Seconds(),
+		OpsPerSec:  float64(n) / elapsed.Seconds(),
+		NsPerOp:    float64(elapsed.Nanoseconds()) / float64(n),
+	}
+}
+
+func main() {
+	var (
+		N       = flag.Int("n", 1_000_000, "number of keys")
+		K       = flag.Int("k", 10, "key size in bytes")
+		V       = flag.Int("v", 0, "value size in bytes, zero stores ints")
+		procs   = flag.Int("procs", runtime.NumCPU(), "number of goroutines")
+		reads   = flag.Int("reads", 90, "percentage of reads in the mixed workload")
+		dist    = flag.String("dist", "uniform", "key distribution for get and mixed: uniform or zipf")
+		zipfS   = flag.Float64("zipf", 1.1, "zipf skew, must be greater than 1")
+		maps    = flag.String("maps", "syncmap,stdmap,cmap,shardmap", "maps to run")
+		format  = flag.String("format", "text", "output format: text, csv, or json")
+		seed    = flag.Int64("seed", time.Now().UnixNano(), "random seed")
+		rngRuns = flag.Int("rng", 100, "number of full ranges")
+	)
+	flag.Parse()
+	if *dist != "uniform" && *dist != "zipf" {
+		fmt.Fprintf(os.Stderr, "invalid -dist: %s\n", *dist)
+		os.Exit(1)
+	}
+	if *zipfS <= 1 {
+		fmt.Fprintf(os.Stderr, "invalid -zipf: %v, must be greater than 1\n",
+			*zipfS)
+		os.Exit(1)
+	}
+	if *format != "text" && *format != "csv" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "invalid -format: %s\n", *format)
+		os.Exit(1)
+	}
+	run := make(map[string]bool)
+	for _, name := range strings.Split(*maps, ",") {
+		run[strings.TrimSpace(name)] = true
+	}
+	text := *format == "text"
+
+	rng := rand.New(rand.NewSource(*seed))
+
+	if text {
+		fmt.Printf("\n")
+		fmt.Printf("go version %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		fmt.Printf("\n")
+		fmt.Printf("     number of cpus: %d\n", runtime.NumCPU())
+		fmt.Printf("     number of keys: %d\n", *N)
+		fmt.Printf("            keysize: %d\n", *K)
+		fmt.Printf("          valuesize: %d\n", *V)
+		fmt.Printf("         goroutines: %d\n", *procs)
+		fmt.Printf("       distribution: %s\n", *dist)
+		fmt.Printf("      mixed %% reads: %d\n", *reads)
+		fmt.Printf("        random seed: %d\n", *seed)
+		fmt.Printf("\n")
+	}
+
+	keysm := make(map[string]bool, *N)
+	for len(keysm) < *N {
+		keysm[randKey(rng, *K)] = true
+	}
+	keys := make([]string, 0, *N)
+	for key := range keysm {
+		keys = append(keys, key)
+	}
+	values := make([]interface{}, *N)
+	for i := range values {
+		if *V > 0 {
+			values[i] = make([]byte, *V)
+		} else {
+			values[i] = i
 		}
-		mu.RUnlock()
-	})
-	print("del: ")
-	lotsa.Ops(N, runtime.NumCPU(), func(i, _ int) {
-		mu.Lock()
-		delete(m, keys[i])
-		mu.Unlock()
-	})
-	println()
-
-	println("-- github.com/orcaman/concurrent-map --")
-	cmap := cmap.New()
-	print("set: ")
-	lotsa.Ops(N, runtime.NumCPU(), func(i, _ int) {
-		cmap.Set(keys[i], i)
-	})
-
-	print("get: ")
-	lotsa.Ops(N, runtime.NumCPU(), func(i, _ int) {
-		v, _ := cmap.Get(keys[i])
-		if v.(int) != i {
-			panic("bad news")
+	}
+
+	// the sequence of keys used by get and mixed
+	seq := make([]int, *N)
+	if *dist == "zipf" {
+		zipf := rand.NewZipf(rng, *zipfS, 1, uint64(*N-1))
+		for i := range seq {
+			seq[i] = int(zipf.Uint64())
+		}
+	} else {
+		for i := range seq {
+			seq[i] = rng.Intn(*N)
 		}
-	})
-	print("rng:       ")
-	lotsa.Ops(100, runtime.NumCPU(), func(i, _ int) {
-		for range cmap.IterBuffered() {
+	}
+	isRead := make([]bool, *N)
+	for i := range isRead {
+		isRead[i] = rng.Intn(100) < *reads
+	}
 
+	var results []result
+	add := func(r result) {
+		results = append(results, r)
+		if text {
+			fmt.Println(r)
 		}
-	})
-	print("del: ")
-	lotsa.Ops(N, runtime.NumCPU(), func(i, _ int) {
-		cmap.Remove(keys[i])
-	})
-
-	println()
-
-	println("-- github.com/tidwall/shardmap --")
-	var com shardmap.Map
-	print("set: ")
-	lotsa.Ops(N, runtime.NumCPU(), func(i, _ int) {
-		com.Set(keys[i], i)
-	})
-
-	print("get: ")
-	lotsa.Ops(N, runtime.NumCPU(), func(i, _ int) {
-		v, _ := com.Get(keys[i])
-		if v.(int) != i {
-			panic("bad news")
+	}
+	for _, t := range targets {
+		if !run[t.name] {
+			continue
+		}
+		if text {
+			fmt.Printf("-- %s --\n", t.title)
+		}
+		m := t.new()
+		add(ops(t.name, "set", *N, *procs, func(i int) {
+			m.set(keys[i], values[i])
+		}))
+		if sm, ok := m.(*shardMap); ok && text {
+			fmt.Printf("dst: %s\n", sm.m.Histogram())
 		}
-	})
-	print("rng:       ")
-	lotsa.Ops(100, runtime.NumCPU(), func(i, _ int) {
-		com.Range(func(key string, value interface{}) bool {
-			return true
-		})
-	})
-	print("del: ")
-	lotsa.Ops(N, runtime.NumCPU(), func(i, _ int) {
-		com.Delete(keys[i])
-	})
-
-	println()
+		add(ops(t.name, "get", *N, *procs, func(i int) {
+			j := seq[i]
+			v, _ := m.get(keys[j])
+			if *V == 0 && v.(int) != j {
+				panic("bad news")
+			}
+		}))
+		add(ops(t.name, "mix", *N, *procs, func(i int) {
+			j := seq[i]
+			if isRead[i] {
+				m.get(keys[j])
+			} else {
+				m.set(keys[j], values[j])
+			}
+		}))
+		add(ops(t.name, "rng", *rngRuns, *procs, func(i int) {
+			m.rng()
+		}))
+		add(ops(t.name, "del", *N, *procs, func(i int) {
+			m.del(keys[i])
+		}))
+		if text {
+			fmt.Println()
+		}
+	}
 
+	switch *format {
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"map", "op", "ops", "goroutines", "seconds",
+			"ops_per_sec", "ns_per_op"})
+		for _, r := range results {
+			w.Write([]string{r.Map, r.Op, strconv.Itoa(r.Ops),
+				strconv.Itoa(r.Goroutines),
+				strconv.FormatFloat(r.Seconds, 'f', -1, 64),
+				strconv.FormatFloat(r.OpsPerSec, 'f', 0, 64),
+				strconv.FormatFloat(r.NsPerOp, 'f', 1, 64)})
+		}
+		w.Flush()
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+	}
 }
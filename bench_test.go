@@ -0,0 +1,133 @@
+package shardmap
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+const benchKeys = 1 << 16
+
+func benchKeyList() []string {
+	keys := make([]string, benchKeys)
+	for i := range keys {
+		keys[i] = k(i)
+	}
+	return keys
+}
+
+func benchMap(keys []string) *Map {
+	m := New(len(keys))
+	for i, key := range keys {
+		m.Set(key, i)
+	}
+	return m
+}
+
+func BenchmarkSet(b *testing.B) {
+	keys := benchKeyList()
+	var m Map
+	var n uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddUint64(&n, 1)
+			m.Set(keys[i&(benchKeys-1)], i)
+		}
+	})
+}
+
+func BenchmarkGetParallel(b *testing.B) {
+	keys := benchKeyList()
+	m := benchMap(keys)
+	var n uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// each goroutine starts at a different key
+		i := atomic.AddUint64(&n, 7919)
+		for pb.Next() {
+			m.Get(keys[i&(benchKeys-1)])
+			i++
+		}
+	})
+}
+
+func BenchmarkMixed(b *testing.B) {
+	keys := benchKeyList()
+	m := benchMap(keys)
+	var n uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := atomic.AddUint64(&n, 7919)
+		for pb.Next() {
+			// 90% reads, 10% writes
+			key := keys[i&(benchKeys-1)]
+			if i%10 == 0 {
+				m.Set(key, i)
+			} else {
+				m.Get(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkRange(b *testing.B) {
+	m := benchMap(benchKeyList())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Range(func(key string, value interface{}) bool {
+			return true
+		})
+	}
+}
+
+// BenchmarkCounterAdd increments a handful of hot counters from every P. The
+// striped counter should pull ahead as the number of cores goes up, and most
+// of all across the sockets of a NUMA machine, such as with -cpu 8,32,64,
+// as long as it uses Inc, because Add reads every stripe.
+func BenchmarkCounterAdd(b *testing.B) {
+	for _, name := range []string{"plain", "striped", "striped-inc"} {
+		c := NewCounter(0)
+		if name != "plain" {
+			c = NewStripedCounter(0)
+		}
+		inc := name == "striped-inc"
+		b.Run(name, func(b *testing.B) {
+			var n uint64
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				i := atomic.AddUint64(&n, 1)
+				key := k(int(i % 4))
+				for pb.Next() {
+					if inc {
+						c.Inc(key, 1)
+					} else {
+						c.Add(key, 1)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkGetParallelSeqLock(b *testing.B) {
+	keys := benchKeyList()
+	m := NewOptions(&Options{Cap: len(keys), SeqLockReads: true})
+	for i, key := range keys {
+		m.Set(key, i)
+	}
+	var n uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := atomic.AddUint64(&n, 7919)
+		for pb.Next() {
+			m.Get(keys[i&(benchKeys-1)])
+			i++
+		}
+	})
+}
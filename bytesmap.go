@@ -0,0 +1,273 @@
+package shardmap
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/cespare/xxhash"
+)
+
+// BytesMap is a hashmap with []byte values. Like map[string][]byte, but
+// sharded and thread-safe.
+//
+// The keys and values are copied into per-shard slabs and indexed by offset,
+// rather than being stored as individual heap objects. This leaves the
+// garbage collector with next to nothing to scan, no matter how many entries
+// are in the map. Fixed-size structs can be stored by encoding them to bytes.
+type BytesMap struct {
+	init   sync.Once
+	cap    int
+	shards int
+	mus    []sync.RWMutex
+	slabs  []*bytesSlab
+}
+
+// bytesSlab holds the entries for one shard. Each record in data is encoded
+// as: uvarint(len(key)) uvarint(len(value)) key value
+type bytesSlab struct {
+	index   map[uint64]uint64 // key hash -> record offset
+	collide map[string]uint64 // keys whose hash is already in index
+	data    []byte
+	garbage int // bytes in data used by replaced or deleted records
+}
+
+// NewBytes returns a new hashmap with the specified capacity. This function
+// is only needed when you must define a minimum capacity, otherwise just use:
+//    var m shardmap.BytesMap
+func NewBytes(cap int) *BytesMap {
+	return &BytesMap{cap: cap}
+}
+
+// Clear out all values from map
+func (m *BytesMap) Clear() {
+	m.initDo()
+	for i := 0; i < m.shards; i++ {
+		m.mus[i].Lock()
+		m.slabs[i] = newBytesSlab(shardCap(m.cap, m.shards))
+		m.mus[i].Unlock()
+	}
+}
+
+// Set assigns a copy of value to a key.
+// Returns a copy of the previous value, or false when no value was assigned.
+func (m *BytesMap) Set(key string, value []byte) (prev []byte, replaced bool) {
+	m.initDo()
+	hash := xxhash.Sum64String(key)
+	shard := int(hash & uint64(m.shards-1))
+	m.mus[shard].Lock()
+	prev, replaced = m.slabs[shard].set(hash, key, value)
+	m.mus[shard].Unlock()
+	return prev, replaced
+}
+
+// Get returns a copy of the value for a key.
+// Returns false when no value has been assign for key.
+func (m *BytesMap) Get(key string) (value []byte, ok bool) {
+	m.initDo()
+	hash := xxhash.Sum64String(key)
+	shard := int(hash & uint64(m.shards-1))
+	m.mus[shard].RLock()
+	s := m.slabs[shard]
+	if off, _, ok := s.find(hash, key); ok {
+		_, v := s.record(off)
+		value = append([]byte{}, v...)
+	}
+	m.mus[shard].RUnlock()
+	return value, value != nil
+}
+
+// Delete deletes a value for a key.
+// Returns a copy of the deleted value, or false when no value was assigned.
+func (m *BytesMap) Delete(key string) (prev []byte, deleted bool) {
+	m.initDo()
+	hash := xxhash.Sum64String(key)
+	shard := int(hash & uint64(m.shards-1))
+	m.mus[shard].Lock()
+	prev, deleted = m.slabs[shard].delete(hash, key)
+	m.mus[shard].Unlock()
+	return prev, deleted
+}
+
+// Len returns the number of values in map.
+func (m *BytesMap) Len() int {
+	m.initDo()
+	var n int
+	for i := 0; i < m.shards; i++ {
+		m.mus[i].RLock()
+		n += len(m.slabs[i].index) + len(m.slabs[i].collide)
+		m.mus[i].RUnlock()
+	}
+	return n
+}
+
+// Range iterates overall all key/values.
+// The value points directly into the shard slab and must not be modified or
+// retained after the iter function returns.
+// It's not safe to call or Set or Delete while ranging.
+func (m *BytesMap) Range(iter func(key string, value []byte) bool) {
+	m.initDo()
+	var done bool
+	for i := 0; i < m.shards && !done; i++ {
+		m.mus[i].RLock()
+		s := m.slabs[i]
+		s.each(func(off uint64) bool {
+			key, value := s.record(off)
+			if !iter(string(key), value) {
+				done = true
+				return false
+			}
+			return true
+		})
+		m.mus[i].RUnlock()
+	}
+}
+
+func (m *BytesMap) initDo() {
+	m.init.Do(func() {
+		m.shards = numShards()
+		m.mus = make([]sync.RWMutex, m.shards)
+		m.slabs = make([]*bytesSlab, m.shards)
+		for i := 0; i < len(m.slabs); i++ {
+			m.slabs[i] = newBytesSlab(shardCap(m.cap, m.shards))
+		}
+	})
+}
+
+func newBytesSlab(cap int) *bytesSlab {
+	return &bytesSlab{index: make(map[uint64]uint64, cap)}
+}
+
+// record returns the key and value stored at offset.
+func (s *bytesSlab) record(off uint64) (key, value []byte) {
+	klen, n := binary.Uvarint(s.data[off:])
+	off += uint64(n)
+	vlen, n := binary.Uvarint(s.data[off:])
+	off += uint64(n)
+	key = s.data[off : off+klen]
+	value = s.data[off+klen : off+klen+vlen]
+	return key, value
+}
+
+// size returns the number of bytes used by the record at offset.
+func (s *bytesSlab) size(off uint64) int {
+	key, value := s.record(off)
+	return uvarintLen(uint64(len(key))) + uvarintLen(uint64(len(value))) +
+		len(key) + len(value)
+}
+
+func uvarintLen(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
+}
+
+// find returns the record offset for a key, and whether the key is in the
+// index rather than the collide map.
+func (s *bytesSlab) find(hash uint64, key string) (
+	off uint64, indexed, ok bool,
+) {
+	if off, ok := s.index[hash]; ok {
+		if k, _ := s.record(off); string(k) == key {
+			return off, true, true
+		}
+	}
+	if len(s.collide) > 0 {
+		off, ok = s.collide[key]
+	}
+	return off, false, ok
+}
+
+func (s *bytesSlab) each(iter func(off uint64) bool) {
+	for _, off := range s.index {
+		if !iter(off) {
+			return
+		}
+	}
+	for _, off := range s.collide {
+		if !iter(off) {
+			return
+		}
+	}
+}
+
+func (s *bytesSlab) append(key string, value []byte) uint64 {
+	off := uint64(len(s.data))
+	var hdr [binary.MaxVarintLen64 * 2]byte
+	n := binary.PutUvarint(hdr[:], uint64(len(key)))
+	n += binary.PutUvarint(hdr[n:], uint64(len(value)))
+	s.data = append(s.data, hdr[:n]...)
+	s.data = append(s.data, key...)
+	s.data = append(s.data, value...)
+	return off
+}
+
+func (s *bytesSlab) set(hash uint64, key string, value []byte) (
+	prev []byte, replaced bool,
+) {
+	off, indexed, replaced := s.find(hash, key)
+	if replaced {
+		_, v := s.record(off)
+		prev = append([]byte{}, v...)
+		if len(v) == len(value) {
+			// overwrite in place
+			copy(v, value)
+			return prev, true
+		}
+		s.garbage += s.size(off)
+	}
+	off = s.append(key, value)
+	if _, taken := s.index[hash]; indexed || (!replaced && !taken) {
+		s.index[hash] = off
+	} else {
+		if s.collide == nil {
+			s.collide = make(map[string]uint64)
+		}
+		s.collide[key] = off
+	}
+	s.compact()
+	return prev, replaced
+}
+
+func (s *bytesSlab) delete(hash uint64, key string) (
+	prev []byte, deleted bool,
+) {
+	off, indexed, deleted := s.find(hash, key)
+	if !deleted {
+		return nil, false
+	}
+	_, v := s.record(off)
+	prev = append([]byte{}, v...)
+	s.garbage += s.size(off)
+	if indexed {
+		delete(s.index, hash)
+	} else {
+		delete(s.collide, key)
+	}
+	s.compact()
+	return prev, true
+}
+
+// compact rewrites the slab data once more than half of it is garbage.
+func (s *bytesSlab) compact() {
+	if s.garbage < 4096 || s.garbage < len(s.data)/2 {
+		return
+	}
+	old := s.data
+	s.data = make([]byte, 0, len(old)-s.garbage)
+	s.garbage = 0
+	move := func(off uint64) uint64 {
+		n := (&bytesSlab{data: old}).size(off)
+		noff := uint64(len(s.data))
+		s.data = append(s.data, old[off:off+uint64(n)]...)
+		return noff
+	}
+	for hash, off := range s.index {
+		s.index[hash] = move(off)
+	}
+	for key, off := range s.collide {
+		s.collide[key] = move(off)
+	}
+}
@@ -0,0 +1,84 @@
+package shardmap
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestBytesMap(t *testing.T) {
+	var m BytesMap
+	exp := make(map[string][]byte)
+	for i := 0; i < 100000; i++ {
+		key := k(rand.Intn(5000))
+		switch rand.Intn(3) {
+		case 0, 1:
+			value := make([]byte, rand.Intn(20))
+			rand.Read(value)
+			prev, replaced := m.Set(key, value)
+			eprev, ereplaced := exp[key]
+			if replaced != ereplaced || !bytes.Equal(prev, eprev) {
+				t.Fatalf("expected %v/%v, got %v/%v",
+					eprev, ereplaced, prev, replaced)
+			}
+			exp[key] = value
+		case 2:
+			prev, deleted := m.Delete(key)
+			eprev, edeleted := exp[key]
+			if deleted != edeleted || !bytes.Equal(prev, eprev) {
+				t.Fatalf("expected %v/%v, got %v/%v",
+					eprev, edeleted, prev, deleted)
+			}
+			delete(exp, key)
+		}
+	}
+	if m.Len() != len(exp) {
+		t.Fatalf("expected %v, got %v", len(exp), m.Len())
+	}
+	for key, value := range exp {
+		if v, ok := m.Get(key); !ok || !bytes.Equal(v, value) {
+			t.Fatalf("expected %v, got %v", value, v)
+		}
+	}
+	var n int
+	m.Range(func(key string, value []byte) bool {
+		if !bytes.Equal(exp[key], value) {
+			t.Fatalf("expected %v, got %v", exp[key], value)
+		}
+		n++
+		return true
+	})
+	if n != len(exp) {
+		t.Fatalf("expected %v, got %v", len(exp), n)
+	}
+	m.Clear()
+	if m.Len() != 0 {
+		t.Fatalf("expected %v, got %v", 0, m.Len())
+	}
+}
+
+func TestBytesSlabCollisions(t *testing.T) {
+	s := newBytesSlab(0)
+	// all keys share the same hash
+	s.set(1, "a", []byte("1"))
+	s.set(1, "b", []byte("2"))
+	s.set(1, "c", []byte("3"))
+	s.delete(1, "a")
+	s.set(1, "b", []byte("22"))
+	s.set(1, "d", []byte("4"))
+	for key, exp := range map[string]string{"b": "22", "c": "3", "d": "4"} {
+		off, _, ok := s.find(1, key)
+		if !ok {
+			t.Fatalf("missing key '%v'", key)
+		}
+		if _, v := s.record(off); string(v) != exp {
+			t.Fatalf("expected '%v', got '%v'", exp, v)
+		}
+	}
+	if _, _, ok := s.find(1, "a"); ok {
+		t.Fatal("expected false")
+	}
+	if n := len(s.index) + len(s.collide); n != 3 {
+		t.Fatalf("expected %v, got %v", 3, n)
+	}
+}
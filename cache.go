@@ -0,0 +1,196 @@
+package shardmap
+
+import "sync"
+
+// Loader loads values that are missing from the map, such as from a database.
+// See Options.Loader. It's called from multiple goroutines at once, and must
+// be thread-safe.
+type Loader interface {
+	Load(key string) (value interface{}, ok bool, err error)
+}
+
+// Writer receives the changes that are made to the map, such as to keep a
+// database up to date. See Options.Writer.
+type Writer interface {
+	Write(key string, value interface{}) error
+	Delete(key string) error
+}
+
+// cache puts the map in front of a Loader and a Writer.
+type cache struct {
+	loader Loader
+	writer Writer
+	queue  chan cacheOp  // write-behind queue, nil when writing through
+	qmu    sync.RWMutex  // keeps Close from closing the queue during Flush
+	done   chan struct{} // closed when run returns
+	mu     sync.Mutex
+	calls  map[string]*loadCall // loads in progress
+	err    error
+	wfails int64 // number of Writer errors, see Checked
+	werr   error // the most recent Writer error
+}
+
+type cacheOp struct {
+	key    string
+	value  interface{}
+	delete bool
+	done   chan struct{} // set by Flush
+}
+
+type loadCall struct {
+	wg    sync.WaitGroup
+	value interface{}
+	ok    bool
+	err   error
+}
+
+func (c *cache) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mu.Unlock()
+}
+
+// write passes a change on to the Writer. It's called with the key's shard
+// write locked, which keeps the changes to each key in order.
+func (c *cache) write(key string, value interface{}, delete bool) {
+	if c.queue != nil {
+		c.queue <- cacheOp{key: key, value: value, delete: delete}
+		return
+	}
+	c.apply(cacheOp{key: key, value: value, delete: delete})
+}
+
+func (c *cache) apply(op cacheOp) {
+	var err error
+	if op.delete {
+		err = c.writer.Delete(op.key)
+	} else {
+		err = c.writer.Write(op.key, op.value)
+	}
+	if err != nil {
+		c.mu.Lock()
+		if c.err == nil {
+			c.err = err
+		}
+		c.wfails++
+		c.werr = err
+		c.mu.Unlock()
+	}
+}
+
+// run writes the queued changes in the background.
+func (c *cache) run() {
+	defer close(c.done)
+	for op := range c.queue {
+		if op.done != nil {
+			close(op.done)
+			continue
+		}
+		c.apply(op)
+	}
+}
+
+// Flush waits until all of the changes that were queued for the Writer have
+// been written. It returns right away unless Options.WriteBehind is set.
+func (m *Map) Flush() {
+	m.initDo()
+	if m.cache == nil || m.cache.queue == nil {
+		return
+	}
+	m.cache.qmu.RLock()
+	if m.isClosed() {
+		// Close has flushed the queue already
+		m.cache.qmu.RUnlock()
+		return
+	}
+	done := make(chan struct{})
+	m.cache.queue <- cacheOp{done: done}
+	m.cache.qmu.RUnlock()
+	<-done
+}
+
+// load loads a missing key using the Loader, and stores the value in the map.
+// Concurrent loads of the same key share a single call to the Loader.
+func (m *Map) load(shard int, key string) (
+	value interface{}, ok bool, err error,
+) {
+	c := m.cache
+	c.mu.Lock()
+	if call := c.calls[key]; call != nil {
+		c.mu.Unlock()
+		call.wg.Wait()
+		return call.value, call.ok, call.err
+	}
+	call := new(loadCall)
+	call.wg.Add(1)
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	value, ok, err = c.loader.Load(key)
+	if err != nil {
+		c.fail(err)
+		value, ok = nil, false
+	}
+	if ok {
+		t := m.lock(shard)
+		m.promote(shard, key)
+		if prev, found := m.lookup(shard, key); found {
+			// assigned while loading, which wins over the loaded value
+			value = prev
+		} else {
+			m.fill(shard, key, value)
+		}
+		m.unlock(shard, t)
+	}
+	call.value, call.ok, call.err = value, ok, err
+	c.mu.Lock()
+	delete(c.calls, key)
+	c.mu.Unlock()
+	call.wg.Done()
+	return value, ok, err
+}
+
+// revalidate reloads a stale key in the background using the Loader, unless
+// it's already being loaded. The reloaded value replaces the stale value only
+// if the key was not assigned in the meantime, and a key that the Loader no
+// longer finds is deleted.
+func (m *Map) revalidate(shard int, key string, e expiry) {
+	c := m.cache
+	c.mu.Lock()
+	if c.calls[key] != nil || m.isClosed() {
+		c.mu.Unlock()
+		return
+	}
+	call := new(loadCall)
+	call.wg.Add(1)
+	c.calls[key] = call
+	m.bg.Add(1)
+	c.mu.Unlock()
+	go func() {
+		defer m.bg.Done()
+		value, ok, err := c.loader.Load(key)
+		if err != nil {
+			c.fail(err)
+		} else {
+			t := m.lock(shard)
+			if m.expires[shard][key] == e {
+				if ok {
+					m.fill(shard, key, value)
+					m.setExpires(shard, key, e.ttl)
+				} else if _, deleted := m.remove(shard, key); deleted {
+					if m.wal != nil {
+						m.wal.delete(key)
+					}
+				}
+			}
+			m.unlock(shard, t)
+		}
+		call.value, call.ok, call.err = value, ok && err == nil, err
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+		call.wg.Done()
+	}()
+}
@@ -0,0 +1,160 @@
+package shardmap
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testDB struct {
+	mu     sync.Mutex
+	data   map[string]interface{}
+	loads  int32
+	delay  time.Duration
+	failOn string
+}
+
+func newTestDB() *testDB {
+	return &testDB{data: make(map[string]interface{})}
+}
+
+func (db *testDB) Load(key string) (interface{}, bool, error) {
+	atomic.AddInt32(&db.loads, 1)
+	time.Sleep(db.delay)
+	if key == db.failOn {
+		return nil, false, errors.New("load failed")
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	value, ok := db.data[key]
+	return value, ok, nil
+}
+
+func (db *testDB) Write(key string, value interface{}) error {
+	if key == db.failOn {
+		return errors.New("write failed")
+	}
+	db.mu.Lock()
+	db.data[key] = value
+	db.mu.Unlock()
+	return nil
+}
+
+func (db *testDB) Delete(key string) error {
+	db.mu.Lock()
+	delete(db.data, key)
+	db.mu.Unlock()
+	return nil
+}
+
+func (db *testDB) get(key string) (interface{}, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	value, ok := db.data[key]
+	return value, ok
+}
+
+func TestReadThrough(t *testing.T) {
+	db := newTestDB()
+	db.data["a"] = 1
+	db.delay = time.Millisecond * 10
+	m := NewOptions(&Options{Loader: db, Writer: db})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, ok := m.Get("a"); !ok || v != 1 {
+				t.Errorf("expected '%v', got '%v'", 1, v)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&db.loads); n != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, n)
+	}
+	if v, ok := m.Get("b"); ok {
+		t.Fatalf("expected '%v', got '%v'", nil, v)
+	}
+	if m.Len() != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, m.Len())
+	}
+	db.failOn = "c"
+	if _, ok := m.Get("c"); ok || m.Err() == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestWriteThrough(t *testing.T) {
+	for _, behind := range []int{0, 4} {
+		db := newTestDB()
+		m := NewOptions(&Options{Writer: db, WriteBehind: behind})
+		for i := 0; i < 100; i++ {
+			m.Set(k(i), i)
+		}
+		for i := 0; i < 100; i += 2 {
+			m.Delete(k(i))
+		}
+		m.Flush()
+		for i := 0; i < 100; i++ {
+			v, ok := db.get(k(i))
+			if ok != (i%2 == 1) || (ok && v != i) {
+				t.Fatalf("expected '%v', got '%v'", i, v)
+			}
+		}
+		db.failOn = "x"
+		m.Set("x", 1)
+		m.Flush()
+		if m.Err() == nil {
+			t.Fatal("expected an error")
+		}
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	db := newTestDB()
+	db.data["a"] = 2
+	clock := &testClock{now: time.Unix(1000, 0)}
+	m := NewOptions(&Options{
+		Loader:               db,
+		Clock:                clock,
+		TrackMeta:            true,
+		StaleWhileRevalidate: time.Minute,
+	})
+	m.SetTTL("a", 1, time.Second)
+	m.SetTTL("b", 1, time.Second)
+	clock.Add(time.Second * 2)
+	meta, _ := m.GetMeta("a")
+	if !meta.Stale || !meta.Expires.Equal(time.Unix(1001, 0)) {
+		t.Fatalf("expected '%v', got '%v'", true, meta.Stale)
+	}
+	// the stale value is served while it's reloaded
+	if v, ok := m.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, v)
+	}
+	if v, ok := m.Get("b"); !ok || v != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, v)
+	}
+	for i := 0; ; i++ {
+		if i == 1000 {
+			t.Fatal("timeout")
+		}
+		_, ok := m.GetMeta("b")
+		if v, _ := m.Get("a"); v == 2 && !ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	meta, _ = m.GetMeta("a")
+	if meta.Stale || !meta.Expires.Equal(time.Unix(1003, 0)) {
+		t.Fatalf("expected '%v', got '%v'", time.Unix(1003, 0), meta.Expires)
+	}
+	// without a reload the entry expires once the window passes
+	db.Delete("a")
+	clock.Add(time.Minute * 2)
+	if v, ok := m.Get("a"); ok {
+		t.Fatalf("expected '%v', got '%v'", nil, v)
+	}
+}
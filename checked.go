@@ -0,0 +1,186 @@
+package shardmap
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrRejected is returned when a write is rejected, such as by
+	// Options.MaxCost.
+	ErrRejected = errors.New("shardmap: rejected")
+	// ErrNoMeta is returned by functions that need metadata when the map
+	// does not track it. See Options.TrackMeta.
+	ErrNoMeta = errors.New("shardmap: map does not track metadata")
+)
+
+// Checked wraps a Map with functions that return errors rather than panicking
+// or failing silently. Create one using Map.Checked.
+//
+// Writes to a map with a log, a Writer, or a spill store also return the
+// error that persisting the write caused, if any. The log stops at its first
+// error, thus every write that follows returns that error too, whereas errors
+// from the Writer and the store are only returned by the write that caused
+// them, or by a concurrent write that was in progress at the time. With Options.WriteBehind, Writer errors happen in the background and
+// are returned by Map.Err instead. Errors from the Loader and from
+// decompressing values are not returned by writes either. The write itself
+// has still been applied to the map. Once the map is closed, all functions
+// return ErrClosed without doing anything.
+type Checked struct {
+	m *Map
+}
+
+// Checked returns the map wrapped in functions that return errors.
+func (m *Map) Checked() *Checked {
+	return &Checked{m: m}
+}
+
+// Map returns the wrapped map.
+func (c *Checked) Map() *Map {
+	return c.m
+}
+
+// Set assigns a value to a key.
+// Returns the previous value, or false when no value was assigned.
+func (c *Checked) Set(key string, value interface{}) (
+	prev interface{}, replaced bool, err error,
+) {
+	if c.m.isClosed() {
+		return nil, false, ErrClosed
+	}
+	fails := c.m.persistFails()
+	prev, replaced = c.m.Set(key, value)
+	return prev, replaced, c.m.persistErr(fails)
+}
+
+// SetWithCost assigns a value to a key along with a cost, see
+// Map.SetWithCost. Returns ErrRejected when the cost would take the total
+// over Options.MaxCost.
+// Returns the previous value, or false when no value was assigned.
+func (c *Checked) SetWithCost(key string, value interface{}, cost int64) (
+	prev interface{}, replaced bool, err error,
+) {
+	if c.m.isClosed() {
+		return nil, false, ErrClosed
+	}
+	fails := c.m.persistFails()
+	prev, replaced, ok := c.m.SetWithCost(key, value, cost)
+	if !ok {
+		return nil, false, ErrRejected
+	}
+	return prev, replaced, c.m.persistErr(fails)
+}
+
+// SetTTL assigns a value to a key that expires after ttl, see Map.SetTTL.
+// Returns the previous value, or false when no value was assigned.
+func (c *Checked) SetTTL(key string, value interface{}, ttl time.Duration) (
+	prev interface{}, replaced bool, err error,
+) {
+	if c.m.isClosed() {
+		return nil, false, ErrClosed
+	}
+	fails := c.m.persistFails()
+	prev, replaced = c.m.SetTTL(key, value, ttl)
+	return prev, replaced, c.m.persistErr(fails)
+}
+
+// SetVersion assigns a value to a key when the current version of the entry
+// is "expected", see Map.SetVersion. Returns ErrNoMeta when the map does not
+// track metadata.
+// Returns the new version, or the current version and false when it did not
+// match.
+func (c *Checked) SetVersion(key string, value interface{}, expected uint64) (
+	version uint64, ok bool, err error,
+) {
+	c.m.initDo()
+	if c.m.isClosed() {
+		return 0, false, ErrClosed
+	}
+	if c.m.metas == nil {
+		return 0, false, ErrNoMeta
+	}
+	fails := c.m.persistFails()
+	version, ok = c.m.SetVersion(key, value, expected)
+	if !ok {
+		return version, false, nil
+	}
+	return version, true, c.m.persistErr(fails)
+}
+
+// Get returns a value for a key. Returns the error from Options.Loader when
+// the key could not be loaded.
+// Returns false when no value has been assign for key.
+func (c *Checked) Get(key string) (value interface{}, ok bool, err error) {
+	if c.m.isClosed() {
+		return nil, false, ErrClosed
+	}
+	return c.m.get(key)
+}
+
+// Delete deletes a value for a key.
+// Returns the deleted value, or false when no value was assigned.
+func (c *Checked) Delete(key string) (
+	prev interface{}, deleted bool, err error,
+) {
+	if c.m.isClosed() {
+		return nil, false, ErrClosed
+	}
+	fails := c.m.persistFails()
+	prev, deleted = c.m.Delete(key)
+	return prev, deleted, c.m.persistErr(fails)
+}
+
+// failCounts counts the errors from the store and the Writer, so that the
+// errors that a write caused can be told apart from the earlier ones.
+type failCounts struct {
+	store  int64
+	writer int64
+}
+
+// persistFails returns the number of errors from the store and the Writer so
+// far, which persistErr compares against after a write.
+func (m *Map) persistFails() failCounts {
+	m.initDo()
+	var n failCounts
+	if m.spill != nil {
+		m.spill.mu.Lock()
+		n.store = m.spill.fails
+		m.spill.mu.Unlock()
+	}
+	if m.cache != nil {
+		m.cache.mu.Lock()
+		n.writer = m.cache.wfails
+		m.cache.mu.Unlock()
+	}
+	return n
+}
+
+// persistErr returns the error from the log, if any, or otherwise the most
+// recent error from the store or the Writer, when there were more of them
+// than before the write.
+func (m *Map) persistErr(before failCounts) error {
+	if m.wal != nil {
+		m.wal.mu.Lock()
+		err := m.wal.err
+		m.wal.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	var err error
+	if m.spill != nil {
+		m.spill.mu.Lock()
+		if m.spill.fails != before.store {
+			err = m.spill.last
+		}
+		m.spill.mu.Unlock()
+	}
+	if m.cache != nil && err == nil {
+		m.cache.mu.Lock()
+		if m.cache.wfails != before.writer {
+			err = m.cache.werr
+		}
+		m.cache.mu.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+package shardmap
+
+import (
+	"errors"
+	"testing"
+)
+
+type failWriter struct{ fail bool }
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if w.fail {
+		return 0, errors.New("disk full")
+	}
+	return len(p), nil
+}
+
+func TestChecked(t *testing.T) {
+	w := new(failWriter)
+	c := NewOptions(&Options{Log: w, MaxCost: 10}).Checked()
+	if _, _, err := c.Set("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.SetWithCost("b", "2", 11); err != ErrRejected {
+		t.Fatalf("expected '%v', got '%v'", ErrRejected, err)
+	}
+	if _, _, err := c.SetVersion("a", "2", 0); err != ErrNoMeta {
+		t.Fatalf("expected '%v', got '%v'", ErrNoMeta, err)
+	}
+	w.fail = true
+	if _, _, err := c.Set("c", "3"); err == nil {
+		t.Fatal("expected an error")
+	}
+	// the write was still applied
+	if v, ok, _ := c.Get("c"); !ok || v != "3" {
+		t.Fatalf("expected '%v', got '%v'", "3", v)
+	}
+	if _, _, err := c.Delete("a"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if c.Map().Len() != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, c.Map().Len())
+	}
+}
+
+func TestCheckedLoader(t *testing.T) {
+	db := newTestDB()
+	db.data["a"] = 1
+	db.failOn = "b"
+	c := NewOptions(&Options{Loader: db, Writer: db}).Checked()
+	if v, ok, err := c.Get("a"); err != nil || !ok || v != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, v)
+	}
+	if _, ok, err := c.Get("b"); err == nil || ok {
+		t.Fatal("expected an error")
+	}
+	// a failed load is not a failed write
+	if _, _, err := c.Set("c", 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Set("b", 2); err == nil {
+		t.Fatal("expected an error")
+	}
+	// only the write that failed returns the error
+	if _, _, err := c.Set("d", 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Delete("c"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Map().Err() == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,88 @@
+package shardmap
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrClosed is returned once the map has been closed. See Map.Close.
+var ErrClosed = errors.New("shardmap: closed")
+
+// Close stops the goroutines that the map runs in the background, which are
+// the sweeper, the snapshots, the write queues, and the Writer queue, after
+// the changes that are waiting in the queues have been applied, and waits for
+// background reloads to finish.
+//
+// Afterwards the map still holds its entries, and its functions keep working
+// in memory, but changes are no longer logged or passed on to the Writer, the
+// Loader is no longer called, and Err returns ErrClosed unless an earlier
+// error occurred. Checked returns ErrClosed from every function. Spilled
+// entries are still read from the store.
+//
+// Returns the error from the last snapshot, or from Err before closing, and
+// ErrClosed when the map was already closed.
+func (m *Map) Close() error {
+	m.initDo()
+	m.closeMu.Lock()
+	defer m.closeMu.Unlock()
+	if m.isClosed() {
+		return ErrClosed
+	}
+	err := m.StopSnapshots()
+	if m.sweep != nil {
+		close(m.sweep.stop)
+		<-m.sweep.done
+	}
+	if err == nil {
+		err = m.Err()
+	}
+
+	// Drain the write queues first, while the map is still open, so that the
+	// queued changes are logged and passed on to the Writer like any other.
+	// The queue locks are held until the map is marked as closed, after which
+	// enqueue applies the changes itself rather than sending to a queue.
+	for i := range m.qmus {
+		m.qmus[i].Lock()
+	}
+	for _, queue := range m.queues {
+		close(queue)
+	}
+	m.writers.Wait()
+
+	// Lock everything else that hands work over to the background, so that
+	// its queue can be closed once the map is marked as closed.
+	if m.cache != nil {
+		m.cache.qmu.Lock()
+	}
+	ts := m.lockAll()
+	atomic.StoreInt32(&m.closed, 1)
+	if m.wal != nil {
+		m.wal.mu.Lock()
+		if m.wal.err == nil {
+			m.wal.err = ErrClosed
+		}
+		m.wal.mu.Unlock()
+	}
+	m.unlockAll(ts, "Close")
+	if m.cache != nil {
+		m.cache.qmu.Unlock()
+	}
+	for i := range m.qmus {
+		m.qmus[i].Unlock()
+	}
+	if m.cache != nil {
+		if m.cache.queue != nil {
+			close(m.cache.queue)
+			<-m.cache.done
+		}
+		// reloads check for closed while holding the mutex
+		m.cache.mu.Lock()
+		m.cache.mu.Unlock()
+		m.bg.Wait()
+	}
+	return err
+}
+
+func (m *Map) isClosed() bool {
+	return atomic.LoadInt32(&m.closed) != 0
+}
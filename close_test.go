@@ -0,0 +1,128 @@
+package shardmap
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClose(t *testing.T) {
+	var log bytes.Buffer
+	db := newTestDB()
+	m := NewOptions(&Options{
+		Log:           &log,
+		WriteQueue:    16,
+		Writer:        db,
+		WriteBehind:   16,
+		SweepInterval: time.Millisecond,
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				m.Set(k(i*1000+j), "v")
+			}
+		}(i)
+	}
+	time.Sleep(time.Millisecond)
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if err := m.Close(); err != ErrClosed {
+		t.Fatalf("expected '%v', got '%v'", ErrClosed, err)
+	}
+	if err := m.Err(); err != ErrClosed {
+		t.Fatalf("expected '%v', got '%v'", ErrClosed, err)
+	}
+	// writes still work in memory, but are no longer persisted
+	n := log.Len()
+	m.Set("x", "y")
+	m.Flush()
+	if v, _ := m.Get("x"); v != "y" || log.Len() != n {
+		t.Fatalf("expected '%v', got '%v'", "y", v)
+	}
+	if _, ok := db.get("x"); ok {
+		t.Fatal("expected false")
+	}
+	if m.Len() != 8001 {
+		t.Fatalf("expected '%v', got '%v'", 8001, m.Len())
+	}
+	if _, _, err := m.Checked().Get("x"); err != ErrClosed {
+		t.Fatalf("expected '%v', got '%v'", ErrClosed, err)
+	}
+}
+
+// gatedWriter is a Writer whose first write waits for release.
+type gatedWriter struct {
+	*testDB
+	writes  int32
+	release chan struct{}
+}
+
+func (w *gatedWriter) Write(key string, value interface{}) error {
+	if atomic.AddInt32(&w.writes, 1) == 1 {
+		<-w.release
+	}
+	return w.testDB.Write(key, value)
+}
+
+func TestCloseLogsQueued(t *testing.T) {
+	var log bytes.Buffer
+	db := &gatedWriter{testDB: newTestDB(), release: make(chan struct{})}
+	m := NewOptions(&Options{
+		Shards: 1, Log: &log, Writer: db, WriteQueue: 64,
+	})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		m.Set("first", "v") // holds up the shard writer in the Writer
+	}()
+	for atomic.LoadInt32(&db.writes) == 0 {
+		runtime.Gosched()
+	}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.Set(k(i), "v")
+		}(i)
+	}
+	for len(m.queues[0]) < 50 {
+		runtime.Gosched()
+	}
+	closed := make(chan error)
+	go func() { closed <- m.Close() }()
+	// wait for Close to get to the write queues
+	for m.qmus[0].TryRLock() {
+		m.qmus[0].RUnlock()
+		runtime.Gosched()
+	}
+	close(db.release)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	var m2 Map
+	if err := m2.LoadFromLog(&log); err != nil {
+		t.Fatal(err)
+	}
+	if m2.Len() != 51 {
+		t.Fatalf("expected '%v', got '%v'", 51, m2.Len())
+	}
+	if len(db.data) != 51 {
+		t.Fatalf("expected '%v', got '%v'", 51, len(db.data))
+	}
+	if err := m.Compact(&bytes.Buffer{}); err != ErrClosed {
+		t.Fatalf("expected '%v', got '%v'", ErrClosed, err)
+	}
+	if err := m.Err(); err != ErrClosed {
+		t.Fatalf("expected '%v', got '%v'", ErrClosed, err)
+	}
+}
@@ -0,0 +1,312 @@
+// Command shardmap-server exposes a shardmap.Map over the Redis protocol
+// (RESP), so that it can be used with any Redis client or benchmark tool,
+// such as redis-cli and redis-benchmark.
+//
+// Supported commands: PING, QUIT, GET, SET (with EX or PX), DEL, EXISTS,
+// SCAN, EXPIRE, TTL, DBSIZE, FLUSHALL.
+//
+//	$ go run ./cmd/shardmap-server -addr :6380
+//	$ redis-benchmark -p 6380 -t set,get -c 100 -n 1000000
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/match"
+	"github.com/tidwall/redcon"
+	"github.com/tidwall/shardmap"
+)
+
+func main() {
+	addr := flag.String("addr", ":6380", "listening address")
+	aof := flag.String("aof", "", "append-only file for persistence")
+	flag.Parse()
+
+	var opts shardmap.Options
+	var f *os.File
+	if *aof != "" {
+		var err error
+		f, err = os.OpenFile(*aof, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() { f.Close() }()
+		opts.Log = f
+	}
+	s := &server{
+		m:       shardmap.NewOptions(&opts),
+		cursors: make(map[uint64]string),
+	}
+	if f != nil {
+		// changes that are loaded are not written back to the log
+		err := s.m.LoadFromLog(f)
+		if err == io.ErrUnexpectedEOF {
+			// a write was cut short, such as by a crash, and everything
+			// before it was loaded
+			log.Printf("dropping the incomplete entry at the end of %s", *aof)
+			f, err = rewrite(s.m, f, *aof)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("loaded %d keys from %s", s.m.Len(), *aof)
+	}
+	log.Printf("listening on %s", *addr)
+	err := redcon.ListenAndServe(*addr, s.handle, nil, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// rewrite replaces the log at path with the entries of the map, using
+// Compact, which leaves out an incomplete entry at the end of the old log.
+// Otherwise the entries that are appended after it could not be read back.
+// Returns the new log, which the map writes to from now on.
+func rewrite(m *shardmap.Map, old *os.File, path string) (*os.File, error) {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_RDWR|os.O_APPEND,
+		0666)
+	if err != nil {
+		return old, err
+	}
+	err = m.Compact(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return old, err
+	}
+	old.Close()
+	return f, nil
+}
+
+// maxCursors is the number of SCAN cursors that are remembered. Beyond that,
+// arbitrary cursors are forgotten, and continuing them starts over.
+const maxCursors = 4096
+
+type server struct {
+	m       *shardmap.Map
+	mu      sync.Mutex
+	cursors map[uint64]string // SCAN cursor -> Page token
+	cursor  uint64            // the last cursor handed out
+}
+
+func (s *server) handle(conn redcon.Conn, cmd redcon.Command) {
+	switch strings.ToLower(string(cmd.Args[0])) {
+	default:
+		conn.WriteError("ERR unknown command '" + string(cmd.Args[0]) + "'")
+	case "ping":
+		if len(cmd.Args) > 2 {
+			conn.WriteError(errArgs(cmd))
+		} else if len(cmd.Args) == 2 {
+			conn.WriteBulk(cmd.Args[1])
+		} else {
+			conn.WriteString("PONG")
+		}
+	case "quit":
+		conn.WriteString("OK")
+		conn.Close()
+	case "get":
+		if len(cmd.Args) != 2 {
+			conn.WriteError(errArgs(cmd))
+			return
+		}
+		v, ok := s.m.Get(string(cmd.Args[1]))
+		if !ok {
+			conn.WriteNull()
+			return
+		}
+		// values that are loaded from the log are []byte
+		switch v := v.(type) {
+		case string:
+			conn.WriteBulkString(v)
+		case []byte:
+			conn.WriteBulk(v)
+		default:
+			conn.WriteAny(v)
+		}
+	case "set":
+		if len(cmd.Args) != 3 && len(cmd.Args) != 5 {
+			conn.WriteError(errArgs(cmd))
+			return
+		}
+		key, value := string(cmd.Args[1]), string(cmd.Args[2])
+		if len(cmd.Args) == 3 {
+			s.m.Set(key, value)
+			conn.WriteString("OK")
+			return
+		}
+		var unit time.Duration
+		switch strings.ToLower(string(cmd.Args[3])) {
+		case "ex":
+			unit = time.Second
+		case "px":
+			unit = time.Millisecond
+		default:
+			conn.WriteError("ERR syntax error")
+			return
+		}
+		n, err := strconv.ParseInt(string(cmd.Args[4]), 10, 64)
+		if err != nil || n < 1 {
+			conn.WriteError("ERR invalid expire time in 'set' command")
+			return
+		}
+		s.m.SetTTL(key, value, time.Duration(n)*unit)
+		conn.WriteString("OK")
+	case "del", "exists":
+		if len(cmd.Args) < 2 {
+			conn.WriteError(errArgs(cmd))
+			return
+		}
+		del := cmd.Args[0][0]|0x20 == 'd'
+		var n int
+		for _, key := range cmd.Args[1:] {
+			var ok bool
+			if del {
+				_, ok = s.m.Delete(string(key))
+			} else {
+				_, ok = s.m.Get(string(key))
+			}
+			if ok {
+				n++
+			}
+		}
+		conn.WriteInt(n)
+	case "expire":
+		if len(cmd.Args) != 3 {
+			conn.WriteError(errArgs(cmd))
+			return
+		}
+		n, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+		if err != nil {
+			conn.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+		var ok bool
+		if n > 0 {
+			ok = s.m.Touch(string(cmd.Args[1]), time.Duration(n)*time.Second)
+		} else {
+			// like Redis, the key is deleted right away
+			_, ok = s.m.Delete(string(cmd.Args[1]))
+		}
+		if ok {
+			conn.WriteInt(1)
+		} else {
+			conn.WriteInt(0)
+		}
+	case "ttl":
+		if len(cmd.Args) != 2 {
+			conn.WriteError(errArgs(cmd))
+			return
+		}
+		ttl, ok := s.m.TTL(string(cmd.Args[1]))
+		switch {
+		case !ok:
+			conn.WriteInt(-2)
+		case ttl < 0:
+			conn.WriteInt(-1)
+		default:
+			// rounded like Redis
+			conn.WriteInt(int((ttl + time.Second/2) / time.Second))
+		}
+	case "dbsize":
+		if len(cmd.Args) != 1 {
+			conn.WriteError(errArgs(cmd))
+			return
+		}
+		conn.WriteInt(s.m.Len())
+	case "flushall":
+		if len(cmd.Args) != 1 {
+			conn.WriteError(errArgs(cmd))
+			return
+		}
+		s.m.Clear()
+		conn.WriteString("OK")
+	case "scan":
+		s.scan(conn, cmd)
+	}
+}
+
+// scan handles SCAN cursor [MATCH pattern] [COUNT count]. Each call visits
+// COUNT keys using Map.Page, and MATCH filters those, like Redis. Clients
+// expect numeric cursors, so the page tokens are kept by the server under
+// cursor numbers.
+func (s *server) scan(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 2 || len(cmd.Args)%2 != 0 {
+		conn.WriteError(errArgs(cmd))
+		return
+	}
+	cursor, err := strconv.ParseUint(string(cmd.Args[1]), 10, 64)
+	if err != nil {
+		conn.WriteError("ERR invalid cursor")
+		return
+	}
+	pattern := "*"
+	count := 10
+	for i := 2; i < len(cmd.Args); i += 2 {
+		switch strings.ToLower(string(cmd.Args[i])) {
+		case "match":
+			pattern = string(cmd.Args[i+1])
+		case "count":
+			n, err := strconv.Atoi(string(cmd.Args[i+1]))
+			if err != nil || n < 1 {
+				conn.WriteError("ERR value is not an integer or out of range")
+				return
+			}
+			count = n
+		default:
+			conn.WriteError("ERR syntax error")
+			return
+		}
+	}
+	var token string
+	if cursor != 0 {
+		s.mu.Lock()
+		token = s.cursors[cursor]
+		s.mu.Unlock()
+	}
+	entries, next := s.m.Page(token, count)
+	var keys []string
+	for _, e := range entries {
+		if pattern == "*" || match.Match(e.Key, pattern) {
+			keys = append(keys, e.Key)
+		}
+	}
+	cursor = 0
+	if next != "" {
+		s.mu.Lock()
+		for c := range s.cursors {
+			if len(s.cursors) < maxCursors {
+				break
+			}
+			delete(s.cursors, c)
+		}
+		s.cursor++
+		cursor = s.cursor
+		s.cursors[cursor] = next
+		s.mu.Unlock()
+	}
+	conn.WriteArray(2)
+	conn.WriteBulkString(strconv.FormatUint(cursor, 10))
+	conn.WriteArray(len(keys))
+	for _, key := range keys {
+		conn.WriteBulkString(key)
+	}
+}
+
+func errArgs(cmd redcon.Command) string {
+	return "ERR wrong number of arguments for '" +
+		strings.ToLower(string(cmd.Args[0])) + "' command"
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/redcon"
+	"github.com/tidwall/shardmap"
+)
+
+// testConn records the replies of the handler, formatted somewhat like
+// redis-cli.
+type testConn struct {
+	redcon.Conn
+	out []string
+}
+
+func (c *testConn) Close() error           { return nil }
+func (c *testConn) WriteError(msg string)  { c.out = append(c.out, msg) }
+func (c *testConn) WriteString(str string) { c.out = append(c.out, str) }
+func (c *testConn) WriteNull()             { c.out = append(c.out, "(nil)") }
+
+func (c *testConn) WriteBulk(bulk []byte) {
+	c.out = append(c.out, string(bulk))
+}
+
+func (c *testConn) WriteBulkString(bulk string) {
+	c.out = append(c.out, bulk)
+}
+
+func (c *testConn) WriteInt(num int) {
+	c.out = append(c.out, "(integer) "+strconv.Itoa(num))
+}
+
+func (c *testConn) WriteArray(count int) {
+	c.out = append(c.out, "*"+strconv.Itoa(count))
+}
+
+func newTestServer(m *shardmap.Map) *server {
+	return &server{m: m, cursors: make(map[uint64]string)}
+}
+
+// do runs a command and returns the replies, joined by spaces.
+func (s *server) do(args ...string) string {
+	var cmd redcon.Command
+	for _, arg := range args {
+		cmd.Args = append(cmd.Args, []byte(arg))
+	}
+	conn := new(testConn)
+	s.handle(conn, cmd)
+	return strings.Join(conn.out, " ")
+}
+
+func TestCommands(t *testing.T) {
+	s := newTestServer(shardmap.New(0))
+	for _, c := range []struct {
+		args []string
+		out  string
+	}{
+		{[]string{"PING"}, "PONG"},
+		{[]string{"QUIT"}, "OK"},
+		{[]string{"GET", "a"}, "(nil)"},
+		{[]string{"SET", "a", "1"}, "OK"},
+		{[]string{"set", "b", "2"}, "OK"},
+		{[]string{"GET", "a"}, "1"},
+		{[]string{"GET"}, "ERR wrong number of arguments for 'get' command"},
+		{[]string{"EXISTS", "a", "b", "c"}, "(integer) 2"},
+		{[]string{"DEL", "a", "c"}, "(integer) 1"},
+		{[]string{"GET", "a"}, "(nil)"},
+		{[]string{"TTL", "b"}, "(integer) -1"},
+		{[]string{"SET", "c", "3", "EX", "100"}, "OK"},
+		{[]string{"TTL", "c"}, "(integer) 100"},
+		{[]string{"SET", "c", "3", "PX", "5000"}, "OK"},
+		{[]string{"TTL", "c"}, "(integer) 5"},
+		{[]string{"SET", "c", "3", "EX", "0"},
+			"ERR invalid expire time in 'set' command"},
+		{[]string{"SET", "c", "3", "NX", "1"}, "ERR syntax error"},
+		{[]string{"EXPIRE", "b", "10"}, "(integer) 1"},
+		{[]string{"TTL", "b"}, "(integer) 10"},
+		{[]string{"EXPIRE", "a", "10"}, "(integer) 0"},
+		{[]string{"EXPIRE", "b", "0"}, "(integer) 1"},
+		{[]string{"EXISTS", "b"}, "(integer) 0"},
+		{[]string{"DBSIZE"}, "(integer) 1"},
+		{[]string{"FLUSHALL"}, "OK"},
+		{[]string{"DBSIZE"}, "(integer) 0"},
+		{[]string{"NOPE"}, "ERR unknown command 'NOPE'"},
+	} {
+		if out := s.do(c.args...); out != c.out {
+			t.Fatalf("%v: expected '%v', got '%v'", c.args, c.out, out)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	s := newTestServer(shardmap.New(0))
+	for i := 0; i < 100; i++ {
+		s.do("SET", "key:"+strconv.Itoa(i), "v")
+	}
+	s.do("SET", "other", "v")
+	seen := make(map[string]bool)
+	cursor := "0"
+	for {
+		out := strings.Fields(
+			s.do("SCAN", cursor, "MATCH", "key:*", "COUNT", "7"))
+		if len(out) < 3 || out[0] != "*2" {
+			t.Fatalf("unexpected reply '%v'", out)
+		}
+		cursor = out[1]
+		for _, key := range out[3:] {
+			if seen[key] {
+				t.Fatalf("key '%v' returned twice", key)
+			}
+			seen[key] = true
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(seen) != 100 || seen["other"] {
+		t.Fatalf("expected '%v', got '%v'", 100, len(seen))
+	}
+	if out := s.do("SCAN", "x"); out != "ERR invalid cursor" {
+		t.Fatalf("expected '%v', got '%v'", "ERR invalid cursor", out)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(shardmap.NewOptions(&shardmap.Options{Log: f}))
+	s.do("SET", "a", "1")
+	s.do("SET", "b", "2")
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.do("SET", "c", "3")
+	f.Close()
+	// cut the last entry short, like a crash would
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data[:fi.Size()+1], 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = newTestServer(shardmap.NewOptions(&shardmap.Options{Log: f}))
+	if err := s.m.LoadFromLog(f); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected '%v', got '%v'", io.ErrUnexpectedEOF, err)
+	}
+	if f, err = rewrite(s.m, f, path); err != nil {
+		t.Fatal(err)
+	}
+	// the values that were loaded are []byte
+	if out := s.do("GET", "a"); out != "1" {
+		t.Fatalf("expected '%v', got '%v'", "1", out)
+	}
+	s.do("SET", "d", "4")
+	f.Close()
+
+	f, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s = newTestServer(shardmap.New(0))
+	if err := s.m.LoadFromLog(f); err != nil {
+		t.Fatal(err)
+	}
+	if out := s.do("EXISTS", "a", "b", "c", "d"); out != "(integer) 3" {
+		t.Fatalf("expected '%v', got '%v'", "(integer) 3", out)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("expected the temporary file to be gone, got '%v'", err)
+	}
+}
@@ -0,0 +1,156 @@
+package shardmap
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compressor compresses values. See Options.Compressor.
+type Compressor interface {
+	// Compress appends the compressed src to dst.
+	Compress(dst, src []byte) []byte
+	// Decompress appends the decompressed src to dst.
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+// compressed is a string or []byte value that's stored compressed.
+type compressed struct {
+	data []byte
+	str  bool
+}
+
+// compressedShard compresses large string and []byte values on their way
+// into the shard, and decompresses them on their way out, so that the rest
+// of the map never sees the compressed form.
+type compressedShard struct {
+	ShardMap
+	c    Compressor
+	min  int
+	fail *compressFail
+}
+
+// compressFail keeps the first error from decompressing a value, for Err.
+type compressFail struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (s *compressedShard) compress(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if len(v) >= s.min {
+			return &compressed{s.c.Compress(nil, []byte(v)), true}
+		}
+	case []byte:
+		if len(v) >= s.min {
+			return &compressed{s.c.Compress(nil, v), false}
+		}
+	}
+	return value
+}
+
+// decompress returns the original value, or false when it's corrupt, in
+// which case the error is kept for Err.
+func (s *compressedShard) decompress(value interface{}) (interface{}, bool) {
+	v, ok := value.(*compressed)
+	if !ok {
+		return value, true
+	}
+	data, err := s.c.Decompress(nil, v.data)
+	if err != nil {
+		s.fail.mu.Lock()
+		if s.fail.err == nil {
+			s.fail.err = fmt.Errorf("shardmap: decompress: %v", err)
+		}
+		s.fail.mu.Unlock()
+		return nil, false
+	}
+	if v.str {
+		return string(data), true
+	}
+	return data, true
+}
+
+// Set returns a nil prev when the previous value was corrupt.
+func (s *compressedShard) Set(key string, value interface{}) (
+	prev interface{}, replaced bool,
+) {
+	prev, replaced = s.ShardMap.Set(key, s.compress(value))
+	prev, _ = s.decompress(prev)
+	return prev, replaced
+}
+
+// Get treats a corrupt value as missing.
+func (s *compressedShard) Get(key string) (value interface{}, ok bool) {
+	if value, ok = s.ShardMap.Get(key); !ok {
+		return nil, false
+	}
+	return s.decompress(value)
+}
+
+// Delete returns a nil prev when the deleted value was corrupt.
+func (s *compressedShard) Delete(key string) (prev interface{}, deleted bool) {
+	prev, deleted = s.ShardMap.Delete(key)
+	prev, _ = s.decompress(prev)
+	return prev, deleted
+}
+
+// Range skips corrupt values.
+func (s *compressedShard) Range(iter func(key string, value interface{}) bool) {
+	s.ShardMap.Range(func(key string, value interface{}) bool {
+		if value, ok := s.decompress(value); ok {
+			return iter(key, value)
+		}
+		return true
+	})
+}
+
+type flateCompressor struct {
+	level   int
+	writers sync.Pool
+	readers sync.Pool
+}
+
+// NewFlate returns a Compressor that uses compress/flate at the specified
+// level, such as flate.BestSpeed. Panics when the level isn't between
+// flate.HuffmanOnly and flate.BestCompression.
+func NewFlate(level int) Compressor {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		panic("shardmap: invalid flate level")
+	}
+	return &flateCompressor{level: level}
+}
+
+func (c *flateCompressor) Compress(dst, src []byte) []byte {
+	buf := bytes.NewBuffer(dst)
+	w, _ := c.writers.Get().(*flate.Writer)
+	if w == nil {
+		// the level was checked by NewFlate
+		w, _ = flate.NewWriter(buf, c.level)
+	} else {
+		w.Reset(buf)
+	}
+	w.Write(src)
+	w.Close()
+	c.writers.Put(w)
+	return buf.Bytes()
+}
+
+func (c *flateCompressor) Decompress(dst, src []byte) ([]byte, error) {
+	r, _ := c.readers.Get().(io.ReadCloser)
+	if r == nil {
+		r = flate.NewReader(bytes.NewReader(src))
+	} else if err := r.(flate.Resetter).Reset(
+		bytes.NewReader(src), nil,
+	); err != nil {
+		return dst, err
+	}
+	buf := bytes.NewBuffer(dst)
+	_, err := io.Copy(buf, r)
+	r.Close()
+	c.readers.Put(r)
+	return buf.Bytes(), err
+}
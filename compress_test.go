@@ -0,0 +1,100 @@
+package shardmap
+
+import (
+	"bytes"
+	"compress/flate"
+	"strings"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	m := NewOptions(&Options{
+		Compressor:  NewFlate(flate.BestSpeed),
+		CompressMin: 100,
+	})
+	big := strings.Repeat("hello world ", 100)
+	m.Set("str", big)
+	m.Set("bytes", []byte(big))
+	m.Set("small", "hello")
+	m.Set("int", 1)
+	raw, _ := m.maps[m.choose("str")].(*compressedShard).ShardMap.Get("str")
+	if c, ok := raw.(*compressed); !ok || len(c.data) >= len(big)/4 {
+		t.Fatalf("expected a compressed value, got %T", raw)
+	}
+	if v, _ := m.Get("str"); v != big {
+		t.Fatalf("expected '%v', got '%v'", big, v)
+	}
+	if v, _ := m.Get("bytes"); !bytes.Equal(v.([]byte), []byte(big)) {
+		t.Fatalf("expected '%v', got '%v'", big, v)
+	}
+	if v, _ := m.Get("small"); v != "hello" {
+		t.Fatalf("expected '%v', got '%v'", "hello", v)
+	}
+	m.Range(func(key string, value interface{}) bool {
+		if key == "str" && value != big {
+			t.Fatalf("expected '%v', got '%v'", big, value)
+		}
+		return true
+	})
+	if prev, _ := m.Set("str", "x"); prev != big {
+		t.Fatalf("expected '%v', got '%v'", big, prev)
+	}
+	if prev, _ := m.Delete("bytes"); !bytes.Equal(prev.([]byte), []byte(big)) {
+		t.Fatalf("expected '%v', got '%v'", big, prev)
+	}
+}
+
+func TestCompressCorrupt(t *testing.T) {
+	m := NewOptions(&Options{
+		Compressor:  NewFlate(flate.BestSpeed),
+		CompressMin: 100,
+	})
+	big := strings.Repeat("hello world ", 100)
+	m.Set("a", big)
+	m.Set("b", big)
+	raw, _ := m.maps[m.choose("a")].(*compressedShard).ShardMap.Get("a")
+	c := raw.(*compressed)
+	c.data = c.data[:len(c.data)/2]
+	if _, ok := m.Get("a"); ok {
+		t.Fatal("expected false")
+	}
+	if err := m.Err(); err == nil {
+		t.Fatal("expected an error")
+	}
+	var keys []string
+	m.Range(func(key string, value interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) != 1 || keys[0] != "b" {
+		t.Fatalf("expected '%v', got '%v'", []string{"b"}, keys)
+	}
+	// the pooled readers still work after a failure
+	for i := 0; i < 10; i++ {
+		if v, _ := m.Get("b"); v != big {
+			t.Fatalf("expected '%v', got '%v'", big, v)
+		}
+	}
+	if prev, deleted := m.Delete("a"); !deleted || prev != nil {
+		t.Fatalf("expected '%v', got '%v'", nil, prev)
+	}
+}
+
+func TestFlateLevel(t *testing.T) {
+	levels := []int{flate.HuffmanOnly - 1, flate.BestCompression + 1}
+	for _, level := range levels {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected a panic for level %d", level)
+				}
+			}()
+			NewFlate(level)
+		}()
+	}
+	c := NewFlate(flate.HuffmanOnly)
+	data, err := c.Decompress(nil, c.Compress(nil, []byte("hello")))
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("expected '%v', got '%v'", "hello", string(data))
+	}
+}
@@ -0,0 +1,93 @@
+//go:build go1.18
+// +build go1.18
+
+package shardmap
+
+import "context"
+
+// GetContext returns a value for a key. It gives up and returns ctx.Err() if
+// ctx is done before the shard can be locked.
+// Returns false when no value has been assign for key.
+func (m *Map) GetContext(ctx context.Context, key string) (
+	value interface{}, ok bool, err error,
+) {
+	m.initDo()
+	shard := m.choose(key)
+	t, err := m.lockContext(ctx, shard, false)
+	if err != nil {
+		return nil, false, err
+	}
+	value, ok = m.lookup(shard, key)
+	spilled := !ok && m.spilled(shard, key)
+	m.runlockOp(shard, t, "GetContext", key)
+	if spilled {
+		value, ok = m.getSpilled(shard, key)
+	}
+	return value, ok, nil
+}
+
+// SetContext assigns a value to a key. It gives up and returns ctx.Err() if
+// ctx is done before the shard can be locked, in which case nothing was
+// assigned.
+// Returns the previous value, or false when no value was assigned.
+func (m *Map) SetContext(ctx context.Context, key string, value interface{}) (
+	prev interface{}, replaced bool, err error,
+) {
+	m.initDo()
+	shard := m.choose(key)
+	t, err := m.lockContext(ctx, shard, true)
+	if err != nil {
+		return nil, false, err
+	}
+	prev, replaced = m.set(shard, key, value)
+	m.unlockOp(shard, t, "SetContext", key)
+	return prev, replaced, nil
+}
+
+// DeleteContext deletes a value for a key. It gives up and returns ctx.Err()
+// if ctx is done before the shard can be locked, in which case nothing was
+// deleted.
+// Returns the deleted value, or false when no value was assigned.
+func (m *Map) DeleteContext(ctx context.Context, key string) (
+	prev interface{}, deleted bool, err error,
+) {
+	m.initDo()
+	shard := m.choose(key)
+	t, err := m.lockContext(ctx, shard, true)
+	if err != nil {
+		return nil, false, err
+	}
+	prev, deleted = m.delete(shard, key)
+	m.unlockOp(shard, t, "DeleteContext", key)
+	return prev, deleted, nil
+}
+
+// lockContext locks a shard, or returns ctx.Err() if ctx is done first.
+func (m *Map) lockContext(ctx context.Context, shard int, write bool) (
+	int64, error,
+) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	lock, unlock, trylock := m.rlock, m.runlock, m.tryrlock
+	if write {
+		lock, unlock, trylock = m.lock, m.unlock, m.trylock
+	}
+	if t, ok := trylock(shard); ok {
+		return t, nil
+	}
+	if ctx.Done() == nil {
+		// never canceled
+		return lock(shard), nil
+	}
+	locked := make(chan int64, 1)
+	go func() { locked <- lock(shard) }()
+	select {
+	case t := <-locked:
+		return t, nil
+	case <-ctx.Done():
+		// release the lock once the goroutine gets it
+		go func() { unlock(shard, <-locked) }()
+		return 0, ctx.Err()
+	}
+}
@@ -0,0 +1,56 @@
+//go:build go1.18
+// +build go1.18
+
+package shardmap
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestContext(t *testing.T) {
+	var m Map
+	ctx := context.Background()
+	prev, replaced, err := m.SetContext(ctx, "hello", "world")
+	if err != nil || replaced || prev != nil {
+		t.Fatalf("expected '%v', got '%v'", nil, prev)
+	}
+	value, ok, err := m.GetContext(ctx, "hello")
+	if err != nil || !ok || value != "world" {
+		t.Fatalf("expected '%v', got '%v'", "world", value)
+	}
+
+	// hold the shard lock while the context times out
+	m.SetAccept("hello", "planet", func(interface{}, bool) bool {
+		ctx, cancel := context.WithTimeout(ctx, time.Millisecond*10)
+		defer cancel()
+		if _, _, err := m.GetContext(ctx, "hello"); err != context.DeadlineExceeded {
+			t.Fatalf("expected '%v', got '%v'", context.DeadlineExceeded, err)
+		}
+		if _, _, err := m.SetContext(ctx, "hello", "moon"); err != context.DeadlineExceeded {
+			t.Fatalf("expected '%v', got '%v'", context.DeadlineExceeded, err)
+		}
+		if _, _, err := m.DeleteContext(ctx, "hello"); err != context.DeadlineExceeded {
+			t.Fatalf("expected '%v', got '%v'", context.DeadlineExceeded, err)
+		}
+		return true
+	})
+
+	// waiting goroutines acquire and release the lock after the timeout
+	ctx2, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	prev, deleted, err := m.DeleteContext(ctx2, "hello")
+	if err != nil || !deleted || prev != "planet" {
+		t.Fatalf("expected '%v', got '%v'", "planet", prev)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := m.SetContext(canceled, "hello", "world"); err != context.Canceled {
+		t.Fatalf("expected '%v', got '%v'", context.Canceled, err)
+	}
+	if m.Len() != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, m.Len())
+	}
+}
@@ -0,0 +1,96 @@
+package shardmap
+
+// ToMap returns a copy of all entries as a standard Go map. Like Range, it
+// skips expired entries, and each shard is read locked only while its own
+// entries are being copied.
+func (m *Map) ToMap() map[string]interface{} {
+	m.initDo()
+	dst := make(map[string]interface{}, m.Len())
+	for i := 0; i < m.shards; i++ {
+		t := m.rlock(i)
+		m.rangeShard(i, func(key string, value interface{}) bool {
+			dst[key] = value
+			return true
+		})
+		m.runlock(i, t)
+	}
+	return dst
+}
+
+// FromMap returns a new map holding all entries of a standard Go map. The
+// entries are grouped by shard first, and then the shards are filled in
+// parallel. The source is left as is.
+func FromMap(src map[string]interface{}) *Map {
+	m := New(len(src))
+	m.initDo()
+	groups := make([][]Entry, m.shards)
+	for key, value := range src {
+		shard := m.choose(key)
+		groups[shard] = append(groups[shard], Entry{key, value})
+	}
+	m.parallel(func(shard int) {
+		if len(groups[shard]) > 0 {
+			m.setGroup(shard, groups[shard], nil)
+			groups[shard] = nil
+		}
+	})
+	return m
+}
+
+// Adopt returns a new map that uses a standard Go map as its storage, rather
+// than copying it, so converting a very large map costs no extra memory. The
+// map has a single shard, which is backed by src, thus all operations on it
+// share one lock. This suits maps that are mostly read, otherwise use
+// FromMap. The map owns src from now on, and the caller must not use it.
+// Keys are not interned. A Go map stores the key that's passed to Set, even
+// when it replaces a value, thus copy keys that are sliced from larger
+// buffers before passing them in.
+func Adopt(src map[string]interface{}) *Map {
+	if src == nil {
+		src = make(map[string]interface{})
+	}
+	m := NewOptions(&Options{
+		Shards: 1,
+		NewShardMap: func(cap int) ShardMap {
+			return make(stdShard, cap)
+		},
+	})
+	m.initDo()
+	m.setShardMap(0, stdShard(src))
+	return m
+}
+
+// stdShard is a standard Go map, as the hashmap of a shard. Unlike the other
+// shard maps, Set replaces the stored key, as there's no way to keep it.
+type stdShard map[string]interface{}
+
+func (s stdShard) Set(key string, value interface{}) (
+	prev interface{}, replaced bool,
+) {
+	prev, replaced = s[key]
+	s[key] = value
+	return prev, replaced
+}
+
+func (s stdShard) Get(key string) (value interface{}, ok bool) {
+	value, ok = s[key]
+	return value, ok
+}
+
+func (s stdShard) Delete(key string) (prev interface{}, deleted bool) {
+	prev, deleted = s[key]
+	delete(s, key)
+	return prev, deleted
+}
+
+func (s stdShard) Len() int {
+	return len(s)
+}
+
+func (s stdShard) Range(iter func(key string, value interface{}) bool) {
+	for key, value := range s {
+		if !iter(key, value) {
+			return
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package shardmap
+
+import "testing"
+
+func TestToMap(t *testing.T) {
+	var m Map
+	for i := 0; i < 1000; i++ {
+		m.Set(k(i), i)
+	}
+	dst := m.ToMap()
+	if len(dst) != 1000 {
+		t.Fatalf("expected '%v', got '%v'", 1000, len(dst))
+	}
+	for i := 0; i < 1000; i++ {
+		if v, ok := dst[k(i)]; !ok || v.(int) != i {
+			t.Fatalf("expected '%v', got '%v'", i, v)
+		}
+	}
+}
+
+func TestFromMap(t *testing.T) {
+	src := make(map[string]interface{})
+	for i := 0; i < 10000; i++ {
+		src[k(i)] = i
+	}
+	m := FromMap(src)
+	if len(src) != 10000 {
+		t.Fatalf("expected '%v', got '%v'", 10000, len(src))
+	}
+	if m.Len() != 10000 {
+		t.Fatalf("expected '%v', got '%v'", 10000, m.Len())
+	}
+	for i := 0; i < 10000; i++ {
+		if v, ok := m.Get(k(i)); !ok || v.(int) != i {
+			t.Fatalf("expected '%v', got '%v'", i, v)
+		}
+	}
+	if m := FromMap(nil); m.Len() != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, m.Len())
+	}
+}
+
+func TestAdopt(t *testing.T) {
+	src := make(map[string]interface{})
+	for i := 0; i < 10000; i++ {
+		src[k(i)] = i
+	}
+	m := Adopt(src)
+	if m.Len() != 10000 {
+		t.Fatalf("expected '%v', got '%v'", 10000, m.Len())
+	}
+	for i := 0; i < 10000; i++ {
+		if v, ok := m.Get(k(i)); !ok || v.(int) != i {
+			t.Fatalf("expected '%v', got '%v'", i, v)
+		}
+	}
+	// the map is the storage
+	m.Set(k(0), "changed")
+	m.Delete(k(1))
+	if src[k(0)] != "changed" || len(src) != 9999 {
+		t.Fatalf("expected '%v', got '%v'", "changed", src[k(0)])
+	}
+	m.Clear()
+	m.Set(k(2), 2)
+	if m.Len() != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, m.Len())
+	}
+	if m := Adopt(nil); m.Len() != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, m.Len())
+	}
+}
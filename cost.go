@@ -0,0 +1,63 @@
+package shardmap
+
+import "sync/atomic"
+
+// SetWithCost assigns a value to a key, along with a cost, which is any
+// user-defined weight such as the size of the value. The costs of all entries
+// are added up per shard and for the whole map, see Cost and ShardCosts.
+// Entries that are assigned by Set have a cost of zero. When Options.MaxCost
+// is set, an assignment that would take the total cost over it is rejected.
+// Returns the previous value, or false when no value was assigned, and false
+// for "ok" when the assignment was rejected.
+func (m *Map) SetWithCost(key string, value interface{}, cost int64) (
+	prev interface{}, replaced, ok bool,
+) {
+	m.initDo()
+	shard := m.choose(key)
+	t := m.lock(shard)
+	defer m.unlockOp(shard, t, "SetWithCost", key)
+	old := m.costs[shard][key]
+	// Reserve the cost up front, so that concurrent assignments on other
+	// shards can't take the total over the limit together.
+	if total := atomic.AddInt64(&m.cost, cost); m.maxCost > 0 &&
+		cost > old && total-old > m.maxCost {
+		atomic.AddInt64(&m.cost, -cost)
+		return nil, false, false
+	}
+	prev, replaced = m.set(shard, key, value)
+	if cost != 0 {
+		if m.costs[shard] == nil {
+			m.costs[shard] = make(map[string]int64)
+		}
+		m.costs[shard][key] = cost
+		m.totals[shard] += cost
+	}
+	return prev, replaced, true
+}
+
+// Cost returns the total cost of all entries.
+func (m *Map) Cost() int64 {
+	m.initDo()
+	return atomic.LoadInt64(&m.cost)
+}
+
+// ShardCosts returns the total cost of the entries in each shard.
+func (m *Map) ShardCosts() []int64 {
+	m.initDo()
+	costs := make([]int64, m.shards)
+	for i := 0; i < m.shards; i++ {
+		t := m.rlock(i)
+		costs[i] = m.totals[i]
+		m.runlock(i, t)
+	}
+	return costs
+}
+
+// removeCost drops the cost of a key in a write locked shard, if any.
+func (m *Map) removeCost(shard int, key string) {
+	if cost, ok := m.costs[shard][key]; ok {
+		delete(m.costs[shard], key)
+		m.totals[shard] -= cost
+		atomic.AddInt64(&m.cost, -cost)
+	}
+}
@@ -0,0 +1,44 @@
+package shardmap
+
+import "testing"
+
+func TestSetWithCost(t *testing.T) {
+	m := NewOptions(&Options{MaxCost: 100})
+	if _, _, ok := m.SetWithCost("a", 1, 60); !ok {
+		t.Fatal("expected true")
+	}
+	if _, _, ok := m.SetWithCost("b", 2, 50); ok {
+		t.Fatal("expected false")
+	}
+	if _, ok := m.Get("b"); ok {
+		t.Fatal("expected false")
+	}
+	if prev, replaced, ok := m.SetWithCost("a", 3, 90); !ok || !replaced ||
+		prev != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, prev)
+	}
+	if m.Cost() != 90 {
+		t.Fatalf("expected '%v', got '%v'", 90, m.Cost())
+	}
+	m.Set("a", 4)
+	if m.Cost() != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, m.Cost())
+	}
+	m.SetWithCost("a", 5, 40)
+	m.SetWithCost("b", 6, 60)
+	var total int64
+	for _, cost := range m.ShardCosts() {
+		total += cost
+	}
+	if total != 100 || m.Stats().Cost != 100 {
+		t.Fatalf("expected '%v', got '%v'", 100, total)
+	}
+	m.Delete("a")
+	if m.Cost() != 60 {
+		t.Fatalf("expected '%v', got '%v'", 60, m.Cost())
+	}
+	m.Clear()
+	if m.Cost() != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, m.Cost())
+	}
+}
@@ -0,0 +1,213 @@
+package shardmap
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// counterStride is the distance between the stripes of a counter, in int64s,
+// which puts every stripe in its own cache line.
+const counterStride = 8
+
+// Counter is a map of int64 counters, meant for high frequency increments
+// such as metrics. Like map[string]int64, but sharded and thread-safe.
+// The counters are not boxed in interfaces, and adding to an existing
+// counter only takes a shard read lock, thus increments on the same shard
+// do not block each other.
+type Counter struct {
+	init    sync.Once
+	cap     int
+	shards  int
+	striped bool
+	stripes int // per counter, a power of two
+	mus     []sync.RWMutex
+	maps    []map[string][]int64 // stripes*counterStride per counter
+}
+
+// NewCounter returns a new counter map with the specified capacity. This
+// function is only needed when you must define a minimum capacity, otherwise
+// just use:
+//    var c shardmap.Counter
+func NewCounter(cap int) *Counter {
+	return &Counter{cap: cap}
+}
+
+// NewStripedCounter returns a new counter map that splits every counter into
+// stripes, one for each P (GOMAXPROCS), each in its own cache line. An Add goes
+// to the stripe that belongs to the P that the goroutine runs on, so that many
+// cores incrementing the same hot counters don't fight over one cache line,
+// which is most costly between the sockets of a NUMA machine. Go has no way to
+// pin goroutines to a P, or to place memory on a NUMA node, so the stripes are
+// picked using the per-P cache of a sync.Pool, which is usually, but not
+// always, the P that the goroutine runs on. This uses more memory per counter,
+// and makes Sum and Add slower, as they read all of the stripes, thus hot paths
+// should use Inc instead of Add. The Map itself can't be grouped like this,
+// because the shard of every key is fixed by its hash.
+func NewStripedCounter(cap int) *Counter {
+	return &Counter{cap: cap, striped: true}
+}
+
+// stripeIDs hands out a stripe number per P.
+var (
+	stripeIDs  sync.Pool
+	nextStripe uint32
+)
+
+// stripe returns the stripe for the calling goroutine.
+func (c *Counter) stripe() int {
+	if c.stripes == 1 {
+		return 0
+	}
+	id, _ := stripeIDs.Get().(*uint32)
+	if id == nil {
+		id = new(uint32)
+		*id = atomic.AddUint32(&nextStripe, 1)
+	}
+	s := int(*id) & (c.stripes - 1)
+	stripeIDs.Put(id)
+	return s * counterStride
+}
+
+// sum adds up the stripes of a counter.
+func sum(n []int64) int64 {
+	var v int64
+	for i := 0; i < len(n); i += counterStride {
+		v += atomic.LoadInt64(&n[i])
+	}
+	return v
+}
+
+// Clear out all counters
+func (c *Counter) Clear() {
+	c.initDo()
+	for i := 0; i < c.shards; i++ {
+		c.mus[i].Lock()
+		c.maps[i] = make(map[string][]int64, shardCap(c.cap, c.shards))
+		c.mus[i].Unlock()
+	}
+}
+
+// Add adds delta to the counter for a key, which starts at zero.
+// Returns the new value of the counter. For a striped counter this reads all
+// of the stripes, use Inc when the new value is not needed.
+func (c *Counter) Add(key string, delta int64) int64 {
+	return c.add(key, delta, true)
+}
+
+// Inc adds delta to the counter for a key, like Add, without returning the
+// new value. For a striped counter this only touches the stripe of the
+// calling goroutine, use Sum to read the value.
+func (c *Counter) Inc(key string, delta int64) {
+	c.add(key, delta, false)
+}
+
+func (c *Counter) add(key string, delta int64, sum bool) int64 {
+	c.initDo()
+	shard := int(Hash(key) & uint64(c.shards-1))
+	s := c.stripe()
+	c.mus[shard].RLock()
+	n, ok := c.maps[shard][key]
+	if ok {
+		v := c.addStripe(n, s, delta, sum)
+		c.mus[shard].RUnlock()
+		return v
+	}
+	c.mus[shard].RUnlock()
+	c.mus[shard].Lock()
+	n, ok = c.maps[shard][key]
+	if !ok {
+		n = make([]int64, (c.stripes-1)*counterStride+1)
+		c.maps[shard][key] = n
+	}
+	v := c.addStripe(n, s, delta, sum)
+	c.mus[shard].Unlock()
+	return v
+}
+
+// addStripe adds delta to a stripe of a counter, and returns the sum of all
+// stripes when "total" is set.
+func (c *Counter) addStripe(
+	n []int64, stripe int, delta int64, total bool,
+) int64 {
+	v := atomic.AddInt64(&n[stripe], delta)
+	if c.stripes == 1 || !total {
+		return v
+	}
+	return sum(n)
+}
+
+// Sum returns the value of the counter for a key, which is zero when the
+// counter does not exist.
+func (c *Counter) Sum(key string) int64 {
+	c.initDo()
+	shard := int(Hash(key) & uint64(c.shards-1))
+	var v int64
+	c.mus[shard].RLock()
+	if n, ok := c.maps[shard][key]; ok {
+		v = sum(n)
+	}
+	c.mus[shard].RUnlock()
+	return v
+}
+
+// Delete deletes the counter for a key.
+// Returns the final value, or false when the counter did not exist.
+func (c *Counter) Delete(key string) (value int64, deleted bool) {
+	c.initDo()
+	shard := int(Hash(key) & uint64(c.shards-1))
+	c.mus[shard].Lock()
+	n, deleted := c.maps[shard][key]
+	if deleted {
+		// the write lock keeps Add out, so no increments are lost
+		value = sum(n)
+		delete(c.maps[shard], key)
+	}
+	c.mus[shard].Unlock()
+	return value, deleted
+}
+
+// Len returns the number of counters.
+func (c *Counter) Len() int {
+	c.initDo()
+	var n int
+	for i := 0; i < c.shards; i++ {
+		c.mus[i].RLock()
+		n += len(c.maps[i])
+		c.mus[i].RUnlock()
+	}
+	return n
+}
+
+// Snapshot returns the values of all counters. Each shard is copied on its
+// own, thus increments that happen during the call may be partially included.
+func (c *Counter) Snapshot() map[string]int64 {
+	c.initDo()
+	snap := make(map[string]int64)
+	for i := 0; i < c.shards; i++ {
+		c.mus[i].RLock()
+		for key, n := range c.maps[i] {
+			snap[key] = sum(n)
+		}
+		c.mus[i].RUnlock()
+	}
+	return snap
+}
+
+func (c *Counter) initDo() {
+	c.init.Do(func() {
+		c.shards = numShards()
+		c.stripes = 1
+		if c.striped {
+			for c.stripes < runtime.GOMAXPROCS(0) {
+				c.stripes *= 2
+			}
+		}
+		scap := shardCap(c.cap, c.shards)
+		c.mus = make([]sync.RWMutex, c.shards)
+		c.maps = make([]map[string][]int64, c.shards)
+		for i := 0; i < len(c.maps); i++ {
+			c.maps[i] = make(map[string][]int64, scap)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package shardmap
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	var c Counter
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10000; j++ {
+				c.Add(k(j%100), 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if c.Len() != 100 {
+		t.Fatalf("expected %v, got %v", 100, c.Len())
+	}
+	for i := 0; i < 100; i++ {
+		if v := c.Sum(k(i)); v != 800 {
+			t.Fatalf("expected %v, got %v", 800, v)
+		}
+	}
+	if v := c.Add(k(0), -800); v != 0 {
+		t.Fatalf("expected %v, got %v", 0, v)
+	}
+	if v, ok := c.Delete(k(1)); !ok || v != 800 {
+		t.Fatalf("expected %v, got %v", 800, v)
+	}
+	if _, ok := c.Delete(k(1)); ok {
+		t.Fatal("expected false")
+	}
+	if v := c.Sum(k(1)); v != 0 {
+		t.Fatalf("expected %v, got %v", 0, v)
+	}
+	snap := c.Snapshot()
+	if len(snap) != 99 || snap[k(2)] != 800 || snap[k(0)] != 0 {
+		t.Fatalf("unexpected snapshot %v", snap)
+	}
+	c.Clear()
+	if c.Len() != 0 {
+		t.Fatalf("expected %v, got %v", 0, c.Len())
+	}
+}
+
+func TestStripedCounter(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+	c := NewStripedCounter(0)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10000; j++ {
+				if j%2 == 0 {
+					c.Add(k(j%10), 1)
+				} else {
+					c.Inc(k(j%10), 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if c.stripes != 8 {
+		t.Fatalf("expected %v, got %v", 8, c.stripes)
+	}
+	for i := 0; i < 10; i++ {
+		if v := c.Sum(k(i)); v != 8000 {
+			t.Fatalf("expected %v, got %v", 8000, v)
+		}
+	}
+	if v := c.Add(k(0), 1); v != 8001 {
+		t.Fatalf("expected %v, got %v", 8001, v)
+	}
+	if v, ok := c.Delete(k(0)); !ok || v != 8001 {
+		t.Fatalf("expected %v, got %v", 8001, v)
+	}
+	if snap := c.Snapshot(); len(snap) != 9 || snap[k(1)] != 8000 {
+		t.Fatalf("unexpected snapshot %v", snap)
+	}
+}
@@ -0,0 +1,123 @@
+package shardmap
+
+import (
+	"strings"
+	"sync"
+)
+
+// Group is a namespace within a Map. Its keys are stored in the map with a
+// prefix that's unique to the group, thus different groups never see each
+// other's keys. Create one using Map.Group.
+type Group struct {
+	m      *Map
+	prefix string
+}
+
+// Group returns the group with the specified name. Groups are not created or
+// stored, this just returns a view over the keys that have the group prefix,
+// which is the length of the name as a uvarint followed by the name. Thus
+// keys that are assigned outside of groups should not start with such bytes.
+func (m *Map) Group(name string) *Group {
+	return &Group{m: m, prefix: groupPrefix(name)}
+}
+
+func groupPrefix(name string) string {
+	return string(appendString(nil, name))
+}
+
+// Set assigns a value to a key in the group.
+// Returns the previous value, or false when no value was assigned.
+func (g *Group) Set(key string, value interface{}) (
+	prev interface{}, replaced bool,
+) {
+	return g.m.Set(g.prefix+key, value)
+}
+
+// Get returns a value for a key in the group.
+// Returns false when no value has been assign for key.
+func (g *Group) Get(key string) (value interface{}, ok bool) {
+	return g.m.Get(g.prefix + key)
+}
+
+// Delete deletes a value for a key in the group.
+// Returns the deleted value, or false when no value was assigned.
+func (g *Group) Delete(key string) (prev interface{}, deleted bool) {
+	return g.m.Delete(g.prefix + key)
+}
+
+// Range iterates over all key/values in the group. See Map.RangePrefix.
+func (g *Group) Range(iter func(key string, value interface{}) bool) {
+	g.m.RangePrefix(g.prefix, func(key string, value interface{}) bool {
+		return iter(key[len(g.prefix):], value)
+	})
+}
+
+// Len returns the number of values in the group. This visits every key in
+// the group, or every key in the map without Options.IndexKeys.
+func (g *Group) Len() int {
+	var n int
+	g.Range(func(key string, value interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// Clear deletes all values in the group. See Map.DeleteGroup.
+func (g *Group) Clear() int {
+	return g.m.deletePrefix(g.prefix)
+}
+
+// DeleteGroup deletes all values in a group, including the spilled ones. The
+// shards are processed in parallel, each under a single lock, and with
+// Options.IndexKeys only the keys in the group are visited.
+// Returns the number of values that were deleted.
+func (m *Map) DeleteGroup(name string) int {
+	return m.deletePrefix(groupPrefix(name))
+}
+
+func (m *Map) deletePrefix(prefix string) int {
+	m.initDo()
+	var mu sync.Mutex
+	var n int
+	m.parallel(func(shard int) {
+		var keys []string
+		t := m.lock(shard)
+		if m.index != nil {
+			m.index[shard].ascend(prefix, func(key string) bool {
+				if !strings.HasPrefix(key, prefix) {
+					return false
+				}
+				keys = append(keys, key)
+				return true
+			})
+		} else {
+			m.maps[shard].Range(func(key string, value interface{}) bool {
+				if strings.HasPrefix(key, prefix) {
+					keys = append(keys, key)
+				}
+				return true
+			})
+		}
+		if m.spill != nil {
+			// spilled keys are neither in the shard map nor in the index
+			for key := range m.spill.shards[shard].spilled {
+				if strings.HasPrefix(key, prefix) {
+					keys = append(keys, key)
+				}
+			}
+		}
+		var deleted int
+		for _, key := range keys {
+			// spilled keys are promoted, and thus removed from the store
+			if _, ok := m.delete(shard, key); ok {
+				deleted++
+			}
+		}
+		m.unlockOp(shard, t, "DeleteGroup", "")
+		mu.Lock()
+		n += deleted
+		mu.Unlock()
+	})
+	return n
+}
@@ -0,0 +1,86 @@
+package shardmap
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGroup(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		m := NewOptions(&Options{IndexKeys: indexed})
+		a, ab := m.Group("a"), m.Group("ab")
+		for i := 0; i < 100; i++ {
+			a.Set(k(i), i)
+			ab.Set(k(i), -i)
+			m.Set(k(i), i)
+		}
+		if v, _ := a.Get("1"); v != 1 {
+			t.Fatalf("expected '%v', got '%v'", 1, v)
+		}
+		if v, _ := ab.Get("1"); v != -1 {
+			t.Fatalf("expected '%v', got '%v'", -1, v)
+		}
+		if prev, _ := ab.Delete("1"); prev != -1 {
+			t.Fatalf("expected '%v', got '%v'", -1, prev)
+		}
+		if a.Len() != 100 || ab.Len() != 99 {
+			t.Fatalf("expected '%v/%v', got '%v/%v'", 100, 99, a.Len(), ab.Len())
+		}
+		ab.Range(func(key string, value interface{}) bool {
+			if value != -add(key, 0) {
+				t.Fatalf("expected '%v', got '%v'", -add(key, 0), value)
+			}
+			return true
+		})
+		if n := m.DeleteGroup("a"); n != 100 {
+			t.Fatalf("expected '%v', got '%v'", 100, n)
+		}
+		if a.Len() != 0 || ab.Len() != 99 || m.Len() != 199 {
+			t.Fatalf("expected '%v', got '%v'", 199, m.Len())
+		}
+		if n := ab.Clear(); n != 99 || m.Len() != 100 {
+			t.Fatalf("expected '%v', got '%v'", 99, n)
+		}
+	}
+}
+
+func TestDeleteGroupSpilled(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		var log bytes.Buffer
+		store := &testStore{data: make(map[string][]byte)}
+		m := NewOptions(&Options{
+			IndexKeys: indexed, Spill: store, SpillLen: 8, Shards: 1,
+			Log: &log,
+		})
+		g := m.Group("g")
+		for i := 0; i < 50; i++ {
+			g.Set(k(i), "v")
+			m.Set(k(i), "v")
+		}
+		if !m.spilled(0, g.prefix+k(0)) {
+			t.Fatal("expected a spilled key")
+		}
+		if n := m.DeleteGroup("g"); n != 50 {
+			t.Fatalf("expected '%v', got '%v'", 50, n)
+		}
+		if m.Len() != 50 {
+			t.Fatalf("expected '%v', got '%v'", 50, m.Len())
+		}
+		if _, ok := g.Get(k(0)); ok {
+			t.Fatal("expected false")
+		}
+		for key := range store.data {
+			if strings.HasPrefix(key, g.prefix) {
+				t.Fatalf("expected '%v' to be deleted from the store", key)
+			}
+		}
+		var m2 Map
+		if err := m2.LoadFromLog(&log); err != nil {
+			t.Fatal(err)
+		}
+		if m2.Len() != 50 {
+			t.Fatalf("expected '%v', got '%v'", 50, m2.Len())
+		}
+	}
+}
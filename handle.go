@@ -0,0 +1,71 @@
+package shardmap
+
+// Handle is a key of a Map, along with its shard, which saves hashing the key
+// on every operation. Create one using Map.Handle. The shard map still hashes
+// the key itself, because the ShardMap interface has no way to pass the hash
+// along. A Handle is thread-safe and never goes stale, not even when the key
+// is deleted.
+type Handle struct {
+	m     *Map
+	key   string
+	shard int
+}
+
+// Handle returns a handle for a key, for keys that are accessed so often
+// that hashing the key adds up.
+func (m *Map) Handle(key string) *Handle {
+	m.initDo()
+	return &Handle{m: m, key: key, shard: m.choose(key)}
+}
+
+// Key returns the key of the handle.
+func (h *Handle) Key() string {
+	return h.key
+}
+
+// Get returns the value of the key. See Map.Get.
+// Returns false when no value has been assign for key.
+func (h *Handle) Get() (value interface{}, ok bool) {
+	value, ok, _ = h.m.getShard(h.shard, h.key)
+	return value, ok
+}
+
+// Set assigns a value to the key. See Map.Set.
+// Returns the previous value, or false when no value was assigned.
+func (h *Handle) Set(value interface{}) (prev interface{}, replaced bool) {
+	return h.m.SetInShard(ShardHint{h.shard}, h.key, value)
+}
+
+// Delete deletes the value of the key. See Map.Delete.
+// Returns the deleted value, or false when no value was assigned.
+func (h *Handle) Delete() (prev interface{}, deleted bool) {
+	m := h.m
+	if m.queues != nil {
+		return m.enqueue(h.shard, h.key, nil, true)
+	}
+	t := m.lock(h.shard)
+	prev, deleted = m.delete(h.shard, h.key)
+	m.unlockOp(h.shard, t, "Delete", h.key)
+	return prev, deleted
+}
+
+// Update replaces the value of the key with the value that the "update"
+// function returns for the previous value, if any, or leaves it as is when
+// the function returns false. This happens under the shard lock, thus the
+// function must not access the map.
+// Returns the new value, or false when the value was not updated.
+func (h *Handle) Update(
+	update func(prev interface{}, replaced bool) (value interface{}, ok bool),
+) (value interface{}, ok bool) {
+	m := h.m
+	t := m.lock(h.shard)
+	defer m.unlockOp(h.shard, t, "Update", h.key)
+	m.expireKey(h.shard, h.key)
+	m.promote(h.shard, h.key)
+	prev, replaced := m.maps[h.shard].Get(h.key)
+	if value, ok = update(prev, replaced); !ok {
+		return nil, false
+	}
+	m.set(h.shard, h.key, value)
+	return value, true
+}
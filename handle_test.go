@@ -0,0 +1,53 @@
+package shardmap
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestHandle(t *testing.T) {
+	m := New(0)
+	h := m.Handle("hits")
+	if h.Key() != "hits" {
+		t.Fatalf("expected '%v', got '%v'", "hits", h.Key())
+	}
+	if _, ok := h.Get(); ok {
+		t.Fatal("expected false")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				h.Update(func(prev interface{}, replaced bool) (interface{}, bool) {
+					if !replaced {
+						return 1, true
+					}
+					return prev.(int) + 1, true
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := m.Get("hits"); v != 8000 {
+		t.Fatalf("expected '%v', got '%v'", 8000, v)
+	}
+	if v, ok := h.Update(func(interface{}, bool) (interface{}, bool) {
+		return nil, false
+	}); ok || v != nil {
+		t.Fatalf("expected '%v', got '%v'", nil, v)
+	}
+	if prev, _ := h.Set(1); prev != 8000 {
+		t.Fatalf("expected '%v', got '%v'", 8000, prev)
+	}
+	if v, _ := h.Get(); v != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, v)
+	}
+	if prev, deleted := h.Delete(); !deleted || prev != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, prev)
+	}
+	if _, ok := m.Get("hits"); ok {
+		t.Fatal("expected false")
+	}
+}
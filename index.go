@@ -0,0 +1,227 @@
+package shardmap
+
+import (
+	"container/heap"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+const indexMaxLevel = 32
+
+// keyIndex is a skiplist that keeps the keys of a shard in lexical order. It's
+// only maintained when Options.IndexKeys is set.
+type keyIndex struct {
+	head  [indexMaxLevel]*indexNode
+	level int
+	seed  uint64
+}
+
+type indexNode struct {
+	key  string
+	next []*indexNode
+}
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{level: 1, seed: 0x9E3779B97F4A7C15}
+}
+
+// randLevel returns the level for a new node. Each level above the first is
+// taken with a probability of 1/4.
+func (x *keyIndex) randLevel() int {
+	x.seed ^= x.seed << 13
+	x.seed ^= x.seed >> 7
+	x.seed ^= x.seed << 17
+	level := 1 + bits.TrailingZeros64(x.seed)/2
+	if level > indexMaxLevel {
+		level = indexMaxLevel
+	}
+	return level
+}
+
+// next returns the node that follows n at level i, where a nil n is the head.
+func (x *keyIndex) next(n *indexNode, i int) *indexNode {
+	if n == nil {
+		return x.head[i]
+	}
+	return n.next[i]
+}
+
+// path fills prev with the last node before key at every level, and returns
+// the first node that is not before key.
+func (x *keyIndex) path(key string, prev *[indexMaxLevel]*indexNode) *indexNode {
+	var n *indexNode
+	for i := x.level - 1; i >= 0; i-- {
+		for {
+			next := x.next(n, i)
+			if next == nil || next.key >= key {
+				break
+			}
+			n = next
+		}
+		prev[i] = n
+	}
+	return x.next(n, 0)
+}
+
+func (x *keyIndex) insert(key string) {
+	var prev [indexMaxLevel]*indexNode
+	if n := x.path(key, &prev); n != nil && n.key == key {
+		return
+	}
+	level := x.randLevel()
+	if level > x.level {
+		x.level = level
+	}
+	n := &indexNode{key: key, next: make([]*indexNode, level)}
+	for i := 0; i < level; i++ {
+		if prev[i] == nil {
+			n.next[i] = x.head[i]
+			x.head[i] = n
+		} else {
+			n.next[i] = prev[i].next[i]
+			prev[i].next[i] = n
+		}
+	}
+}
+
+func (x *keyIndex) remove(key string) {
+	var prev [indexMaxLevel]*indexNode
+	n := x.path(key, &prev)
+	if n == nil || n.key != key {
+		return
+	}
+	for i := 0; i < len(n.next); i++ {
+		if prev[i] == nil {
+			x.head[i] = n.next[i]
+		} else {
+			prev[i].next[i] = n.next[i]
+		}
+	}
+	for x.level > 1 && x.head[x.level-1] == nil {
+		x.level--
+	}
+}
+
+// ascend calls iter for every key that is not less than pivot, in order.
+func (x *keyIndex) ascend(pivot string, iter func(key string) bool) {
+	var prev [indexMaxLevel]*indexNode
+	for n := x.path(pivot, &prev); n != nil; n = n.next[0] {
+		if !iter(n.key) {
+			return
+		}
+	}
+}
+
+// ascendShard calls iter for every key/value of a locked shard, whose key is
+// not less than pivot, in order of the index. Like rangeShard, it skips
+// expired entries.
+func (m *Map) ascendShard(
+	shard int, pivot string, iter func(key string, value interface{}) bool,
+) {
+	var now int64
+	ttl := len(m.expires[shard]) > 0
+	if ttl {
+		now = m.now()
+	}
+	m.index[shard].ascend(pivot, func(key string) bool {
+		if ttl && m.expiredAt(shard, key, now) {
+			return true
+		}
+		value, _ := m.maps[shard].Get(key)
+		return iter(key, value)
+	})
+}
+
+// RangePrefix iterates over all key/values where the key starts with prefix.
+// Without Options.IndexKeys this is a filtered scan over the whole map. With
+// the index only the matching keys are visited, in lexical order per shard.
+// It's not safe to call or Set or Delete while ranging.
+func (m *Map) RangePrefix(
+	prefix string, iter func(key string, value interface{}) bool,
+) {
+	m.initDo()
+	var done bool
+	for i := 0; i < m.shards && !done; i++ {
+		t := m.rlock(i)
+		if m.index != nil {
+			m.ascendShard(i, prefix, func(key string, value interface{}) bool {
+				if !strings.HasPrefix(key, prefix) {
+					return false
+				}
+				done = !iter(key, value)
+				return !done
+			})
+		} else {
+			m.rangeShard(i, func(key string, value interface{}) bool {
+				if strings.HasPrefix(key, prefix) {
+					done = !iter(key, value)
+				}
+				return !done
+			})
+		}
+		m.runlockOp(i, t, "RangePrefix", "")
+	}
+}
+
+// RangeSorted iterates over all key/values in lexical order of the keys.
+// Each shard is copied and sorted while it's read locked, and the shards are
+// then merged without holding any locks, thus it's safe to call Set or Delete
+// while ranging. With Options.IndexKeys the shards are already sorted, which
+// saves the sorting step.
+func (m *Map) RangeSorted(iter func(key string, value interface{}) bool) {
+	m.initDo()
+	lists := make([][]Entry, 0, m.shards)
+	for i := 0; i < m.shards; i++ {
+		t := m.rlock(i)
+		entries := make([]Entry, 0, m.maps[i].Len())
+		add := func(key string, value interface{}) bool {
+			entries = append(entries, Entry{key, value})
+			return true
+		}
+		if m.index != nil {
+			m.ascendShard(i, "", add)
+		} else {
+			m.rangeShard(i, add)
+		}
+		m.runlockOp(i, t, "RangeSorted", "")
+		if len(entries) == 0 {
+			continue
+		}
+		if m.index == nil {
+			sort.Slice(entries, func(i, j int) bool {
+				return entries[i].Key < entries[j].Key
+			})
+		}
+		lists = append(lists, entries)
+	}
+	// merge using a min-heap of lists, ordered by their first key
+	h := entryHeap(lists)
+	heap.Init(&h)
+	for len(h) > 0 {
+		e := h[0][0]
+		if !iter(e.Key, e.Value) {
+			return
+		}
+		if h[0] = h[0][1:]; len(h[0]) == 0 {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+}
+
+type entryHeap [][]Entry
+
+func (h entryHeap) Len() int           { return len(h) }
+func (h entryHeap) Less(i, j int) bool { return h[i][0].Key < h[j][0].Key }
+func (h entryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *entryHeap) Push(x interface{}) {
+	*h = append(*h, x.([]Entry))
+}
+func (h *entryHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,123 @@
+package shardmap
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestKeyIndex(t *testing.T) {
+	x := newKeyIndex()
+	exp := make(map[string]bool)
+	for i := 0; i < 50000; i++ {
+		key := k(rand.Intn(2000))
+		if rand.Intn(3) == 0 {
+			x.remove(key)
+			delete(exp, key)
+		} else {
+			x.insert(key)
+			exp[key] = true
+		}
+	}
+	var keys []string
+	for key := range exp {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var i int
+	x.ascend("", func(key string) bool {
+		if i >= len(keys) || key != keys[i] {
+			t.Fatalf("unexpected key '%v' at %v", key, i)
+		}
+		i++
+		return true
+	})
+	if i != len(keys) {
+		t.Fatalf("expected '%v', got '%v'", len(keys), i)
+	}
+	pivot := keys[len(keys)/2]
+	x.ascend(pivot, func(key string) bool {
+		if key != pivot {
+			t.Fatalf("expected '%v', got '%v'", pivot, key)
+		}
+		return false
+	})
+}
+
+func testRangePrefix(t *testing.T, m *Map) {
+	for i := 0; i < 1000; i++ {
+		m.Set(k(i), i)
+	}
+	exp := make(map[string]interface{})
+	for i := 0; i < 1000; i++ {
+		if key := k(i); strings.HasPrefix(key, "12") {
+			exp[key] = i
+		}
+	}
+	m.Delete("120")
+	delete(exp, "120")
+	got := make(map[string]interface{})
+	m.RangePrefix("12", func(key string, value interface{}) bool {
+		got[key] = value
+		return true
+	})
+	if len(got) != len(exp) {
+		t.Fatalf("expected '%v', got '%v'", len(exp), len(got))
+	}
+	for key, value := range exp {
+		if got[key] != value {
+			t.Fatalf("expected '%v', got '%v'", value, got[key])
+		}
+	}
+	var n int
+	m.RangePrefix("1", func(key string, value interface{}) bool {
+		n++
+		return n < 5
+	})
+	if n != 5 {
+		t.Fatalf("expected '%v', got '%v'", 5, n)
+	}
+	m.Clear()
+	m.RangePrefix("", func(key string, value interface{}) bool {
+		t.Fatal("expected empty map")
+		return false
+	})
+}
+
+func TestRangePrefix(t *testing.T) {
+	t.Run("scan", func(t *testing.T) {
+		testRangePrefix(t, new(Map))
+	})
+	t.Run("indexed", func(t *testing.T) {
+		testRangePrefix(t, NewOptions(&Options{IndexKeys: true}))
+	})
+}
+
+func TestRangeSorted(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		m := NewOptions(&Options{IndexKeys: indexed})
+		var keys []string
+		for i := 0; i < 1000; i++ {
+			m.Set(k(i), i)
+			keys = append(keys, k(i))
+		}
+		sort.Strings(keys)
+		var i int
+		m.RangeSorted(func(key string, value interface{}) bool {
+			if key != keys[i] {
+				t.Fatalf("expected '%v', got '%v'", keys[i], key)
+			}
+			if v, _ := m.Get(key); v != value {
+				t.Fatalf("expected '%v', got '%v'", v, value)
+			}
+			// writing while ranging must not deadlock
+			m.Set(key, value)
+			i++
+			return i < 500
+		})
+		if i != 500 {
+			t.Fatalf("expected '%v', got '%v'", 500, i)
+		}
+	}
+}
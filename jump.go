@@ -0,0 +1,17 @@
+package shardmap
+
+// JumpHash returns the bucket in [0,buckets) for a key hash, using the jump
+// consistent hash by Lamping and Veach. When the number of buckets grows from
+// n to n+1, only about 1/(n+1) of the keys move, and all of them move to the
+// new bucket. This is the shard selection used with Options.JumpHash, and is
+// part of the API, so that external systems can partition their own data the
+// same way, such as with JumpHash(Hash(key), m.NumShards()).
+func JumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,41 @@
+package shardmap
+
+import "testing"
+
+func TestJumpHash(t *testing.T) {
+	const n = 10000
+	for buckets := 1; buckets < 64; buckets++ {
+		var moved int
+		counts := make([]int, buckets+1)
+		for i := 0; i < n; i++ {
+			h := Hash(k(i))
+			a, b := JumpHash(h, buckets), JumpHash(h, buckets+1)
+			if a < 0 || a >= buckets {
+				t.Fatalf("bucket %d out of range [0,%d)", a, buckets)
+			}
+			if a != b {
+				if b != buckets {
+					t.Fatalf("expected '%v', got '%v'", buckets, b)
+				}
+				moved++
+			}
+			counts[b]++
+		}
+		if exp := n / (buckets + 1); moved < exp/2 || moved > exp*2 {
+			t.Fatalf("expected about '%v' moved keys, got '%v'", exp, moved)
+		}
+	}
+	m := NewOptions(&Options{JumpHash: true, Shards: 16})
+	for i := 0; i < 1000; i++ {
+		m.Set(k(i), i)
+		if m.Shard(k(i)) != JumpHash(Hash(k(i)), 16) {
+			t.Fatalf("expected '%v', got '%v'", JumpHash(Hash(k(i)), 16),
+				m.Shard(k(i)))
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if v, _ := m.Get(k(i)); v != i {
+			t.Fatalf("expected '%v', got '%v'", i, v)
+		}
+	}
+}
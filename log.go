@@ -0,0 +1,376 @@
+package shardmap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Log entry types. Every entry starts with one of these bytes.
+//
+//	set:    'S' uvarint(len(key)) key uvarint(len(value)) value
+//	delete: 'D' uvarint(len(key)) key
+//	clear:  'C'
+//	expire: 'E' uvarint(len(key)) key uvarint(deadline) uvarint(ttl)
+//
+// The deadline of an expire entry is the wall clock time at which the key
+// expires, in Unix nanoseconds, and the ttl is in nanoseconds. Both are zero
+// when the expiration is removed. A set entry removes the expiration too.
+const (
+	logSet    = 'S'
+	logDelete = 'D'
+	logClear  = 'C'
+	logExpire = 'E'
+)
+
+// ErrInvalidLog is returned by LoadFromLog when the log is malformed.
+var ErrInvalidLog = errors.New("shardmap: invalid log")
+
+// codec encodes and decodes values for the log.
+type codec struct {
+	marshal   func(value interface{}) ([]byte, error)
+	unmarshal func(data []byte) (interface{}, error)
+}
+
+func (c codec) encode(value interface{}) ([]byte, error) {
+	if c.marshal != nil {
+		return c.marshal(value)
+	}
+	switch v := value.(type) {
+	case string:
+		return append([]byte{'s'}, v...), nil
+	case []byte:
+		return append([]byte{'b'}, v...), nil
+	}
+	return nil, fmt.Errorf("shardmap: cannot marshal value of type %T", value)
+}
+
+func (c codec) decode(data []byte) (interface{}, error) {
+	if c.unmarshal != nil {
+		return c.unmarshal(data)
+	}
+	if len(data) > 0 {
+		switch data[0] {
+		case 's':
+			return string(data[1:]), nil
+		case 'b':
+			return append([]byte{}, data[1:]...), nil
+		}
+	}
+	return nil, ErrInvalidLog
+}
+
+// wal writes changes to the log. The first error stops all further writes,
+// because the log no longer matches the map.
+type wal struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+	err error
+}
+
+func (l *wal) write(buf []byte) {
+	if l.err == nil {
+		_, l.err = l.w.Write(buf)
+	}
+}
+
+func (l *wal) set(c codec, key string, value interface{}) {
+	data, err := c.encode(value)
+	l.mu.Lock()
+	if err != nil {
+		if l.err == nil {
+			l.err = err
+		}
+	} else {
+		l.buf = appendSet(l.buf[:0], key, data)
+		l.write(l.buf)
+	}
+	l.mu.Unlock()
+}
+
+func (l *wal) delete(key string) {
+	l.mu.Lock()
+	l.buf = append(l.buf[:0], logDelete)
+	l.buf = appendString(l.buf, key)
+	l.write(l.buf)
+	l.mu.Unlock()
+}
+
+func (l *wal) expire(key string, deadline int64, ttl time.Duration) {
+	l.mu.Lock()
+	l.buf = appendExpire(l.buf[:0], key, deadline, ttl)
+	l.write(l.buf)
+	l.mu.Unlock()
+}
+
+func (l *wal) clear() {
+	l.mu.Lock()
+	l.write([]byte{logClear})
+	l.mu.Unlock()
+}
+
+func appendUvarint(dst []byte, x uint64) []byte {
+	var n [binary.MaxVarintLen64]byte
+	return append(dst, n[:binary.PutUvarint(n[:], x)]...)
+}
+
+func appendString(dst []byte, s string) []byte {
+	return append(appendUvarint(dst, uint64(len(s))), s...)
+}
+
+func appendSet(dst []byte, key string, data []byte) []byte {
+	dst = append(dst, logSet)
+	dst = appendString(dst, key)
+	return append(appendUvarint(dst, uint64(len(data))), data...)
+}
+
+func appendExpire(
+	dst []byte, key string, deadline int64, ttl time.Duration,
+) []byte {
+	dst = append(dst, logExpire)
+	dst = appendString(dst, key)
+	dst = appendUvarint(dst, uint64(deadline))
+	return appendUvarint(dst, uint64(ttl))
+}
+
+// Err returns the first error that occurred while writing to the log, if any.
+// No more changes are written to the log after an error.
+// When spilling to a store, Err also returns the first error from the store,
+// and likewise for the Loader, the Writer, and for values that fail to
+// decompress. Returns ErrClosed after Close.
+func (m *Map) Err() error {
+	if m.wal != nil {
+		m.wal.mu.Lock()
+		err := m.wal.err
+		m.wal.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	if m.spill != nil {
+		m.spill.mu.Lock()
+		err := m.spill.err
+		m.spill.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	if m.cache != nil {
+		m.cache.mu.Lock()
+		err := m.cache.err
+		m.cache.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	if m.compFail != nil {
+		m.compFail.mu.Lock()
+		err := m.compFail.err
+		m.compFail.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	if m.isClosed() {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Save writes all entries to w, in the same format as the log, so that they
+// can be loaded back using LoadFromLog. Each shard is read locked only while
+// its own entries are being written, which keeps writers mostly unaffected,
+// but means that the saved entries are not a point-in-time copy of the map.
+// Use Compact for that.
+func (m *Map) Save(w io.Writer) error {
+	m.initDo()
+	var buf []byte
+	for i := 0; i < m.shards; i++ {
+		var err error
+		t := m.rlock(i)
+		buf, err = m.appendShard(buf[:0], i)
+		m.runlock(i, t)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendShard appends set entries for all key/values in a read locked shard,
+// including the spilled ones, each followed by an expire entry when the key
+// has a TTL. Expired entries are left out.
+func (m *Map) appendShard(dst []byte, shard int) ([]byte, error) {
+	var err error
+	m.rangeShard(shard, func(key string, value interface{}) bool {
+		var data []byte
+		data, err = m.codec.encode(value)
+		if err != nil {
+			return false
+		}
+		dst = appendSet(dst, key, data)
+		if e, ok := m.expires[shard][key]; ok {
+			dst = appendExpire(dst, key, m.deadline(e), e.ttl)
+		}
+		return true
+	})
+	if err != nil {
+		return dst, err
+	}
+	// spilled values are stored encoded already
+	err = m.rangeSpilled(shard, func(key string, data []byte) bool {
+		dst = appendSet(dst, key, data)
+		if e, ok := m.expires[shard][key]; ok {
+			dst = appendExpire(dst, key, m.deadline(e), e.ttl)
+		}
+		return true
+	})
+	return dst, err
+}
+
+// Compact writes all entries to w, and then switches the log over to w. This
+// is the same as Save, except that all shards are read locked together so
+// that w starts with a point-in-time copy of the map, and then continues with
+// the changes that follow. Afterwards the previous log is no longer needed.
+// Returns an error if the map has no log, and ErrClosed once the map is
+// closed.
+func (m *Map) Compact(w io.Writer) error {
+	m.initDo()
+	if m.wal == nil {
+		return errors.New("shardmap: map has no log")
+	}
+	ts := m.rlockAll()
+	defer m.runlockAll(ts, "Compact")
+	// Close marks the map as closed with all shards locked
+	if m.isClosed() {
+		return ErrClosed
+	}
+	var buf []byte
+	for i := 0; i < m.shards; i++ {
+		var err error
+		buf, err = m.appendShard(buf[:0], i)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	m.wal.mu.Lock()
+	m.wal.w = w
+	m.wal.err = nil
+	m.wal.mu.Unlock()
+	return nil
+}
+
+// LoadFromLog applies all of the changes in r to the map. These changes are
+// not written to the map's own log. Returns io.ErrUnexpectedEOF when the last
+// entry is incomplete, such as after a crash, in which case all of the entries
+// before it have still been applied.
+func (m *Map) LoadFromLog(r io.Reader) error {
+	m.initDo()
+	br := bufio.NewReader(r)
+	var key, data []byte
+	for {
+		op, err := br.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch op {
+		case logSet, logDelete:
+			if key, err = readString(br, key); err != nil {
+				return err
+			}
+			shard := m.choose(string(key))
+			if op == logDelete {
+				t := m.lock(shard)
+				m.remove(shard, string(key))
+				m.unlock(shard, t)
+				continue
+			}
+			if data, err = readString(br, data); err != nil {
+				return err
+			}
+			value, err := m.codec.decode(data)
+			if err != nil {
+				return err
+			}
+			t := m.lock(shard)
+			m.put(shard, string(key), value)
+			m.unlock(shard, t)
+		case logExpire:
+			if key, err = readString(br, key); err != nil {
+				return err
+			}
+			deadline, err := readUvarint(br)
+			if err != nil {
+				return err
+			}
+			ttl, err := readUvarint(br)
+			if err != nil {
+				return err
+			}
+			shard := m.choose(string(key))
+			t := m.lock(shard)
+			if _, ok := m.maps[shard].Get(string(key)); ok {
+				if ttl == 0 {
+					delete(m.expires[shard], string(key))
+				} else {
+					at := time.Unix(0, int64(deadline)).Sub(clockEpoch)
+					m.storeExpiry(shard, string(key),
+						expiry{int64(at), time.Duration(ttl)})
+				}
+			}
+			m.unlock(shard, t)
+		case logClear:
+			for i := 0; i < m.shards; i++ {
+				t := m.lock(i)
+				m.reset(i)
+				m.unlock(i, t)
+			}
+		default:
+			return ErrInvalidLog
+		}
+	}
+}
+
+func readUvarint(r *bufio.Reader) (uint64, error) {
+	n, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func readString(r *bufio.Reader, buf []byte) ([]byte, error) {
+	n, err := readUvarint(r)
+	if err != nil {
+		return buf, err
+	}
+	if n > math.MaxInt32 {
+		return buf, ErrInvalidLog
+	}
+	if uint64(cap(buf)) < n {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return buf, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,134 @@
+package shardmap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	var log bytes.Buffer
+	m := NewOptions(&Options{Log: &log})
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprintf("%d", i), fmt.Sprintf("v%d", i))
+	}
+	for i := 0; i < 100; i++ {
+		m.Delete(fmt.Sprintf("%d", i))
+	}
+	m.SetAccept("100", "rejected", func(interface{}, bool) bool { return false })
+	m.DeleteAccept("101", func(interface{}, bool) bool { return false })
+	m.Set("bytes", []byte("data"))
+	if err := m.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	m2 := new(Map)
+	if err := m2.LoadFromLog(bytes.NewReader(log.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	eq := func(a, b interface{}) bool { return fmt.Sprint(a) == fmt.Sprint(b) }
+	if !m.Equal(m2, eq) {
+		t.Fatal("expected equal maps")
+	}
+
+	// incomplete trailing entry
+	m3 := new(Map)
+	err := m3.LoadFromLog(bytes.NewReader(log.Bytes()[:log.Len()-2]))
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected '%v', got '%v'", io.ErrUnexpectedEOF, err)
+	}
+	if m3.Len() != m.Len()-1 {
+		t.Fatalf("expected '%v', got '%v'", m.Len()-1, m3.Len())
+	}
+
+	// compact, then continue logging to the new writer
+	var log2 bytes.Buffer
+	if err := m.Compact(&log2); err != nil {
+		t.Fatal(err)
+	}
+	n := log.Len()
+	m.Set("after", "compact")
+	if log.Len() != n {
+		t.Fatal("expected no writes to the old log")
+	}
+	m4 := new(Map)
+	if err := m4.LoadFromLog(&log2); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Equal(m4, eq) {
+		t.Fatal("expected equal maps")
+	}
+
+	m.Clear()
+	m5 := new(Map)
+	m5.Set("stale", "value")
+	if err := m5.LoadFromLog(bytes.NewReader(log2.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if m5.Len() != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, m5.Len())
+	}
+}
+
+func TestLogErrors(t *testing.T) {
+	var log bytes.Buffer
+	m := NewOptions(&Options{Log: &log})
+	m.Set("a", "1")
+	m.Set("b", 2)
+	if m.Err() == nil {
+		t.Fatal("expected an error")
+	}
+	n := log.Len()
+	m.Set("c", "3")
+	if log.Len() != n {
+		t.Fatal("expected no writes after an error")
+	}
+	if err := new(Map).Compact(&log); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := new(Map).LoadFromLog(bytes.NewReader([]byte("X"))); err != ErrInvalidLog {
+		t.Fatalf("expected '%v', got '%v'", ErrInvalidLog, err)
+	}
+
+	// custom codec
+	m = NewOptions(&Options{
+		Log: &log,
+		Marshal: func(value interface{}) ([]byte, error) {
+			return []byte(fmt.Sprint(value)), nil
+		},
+		Unmarshal: func(data []byte) (interface{}, error) {
+			return nil, errors.New("nope")
+		},
+	})
+	m.Set("b", 2)
+	if err := m.Err(); err != nil {
+		t.Fatal(err)
+	}
+	var saved bytes.Buffer
+	if err := m.Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.LoadFromLog(&saved); err == nil || err.Error() != "nope" {
+		t.Fatalf("expected '%v', got '%v'", "nope", err)
+	}
+}
+
+func TestSave(t *testing.T) {
+	var m Map
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprintf("%d", i), fmt.Sprintf("v%d", i))
+	}
+	var buf bytes.Buffer
+	if err := m.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var m2 Map
+	if err := m2.LoadFromLog(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Equal(&m2, nil) {
+		t.Fatal("expected equal maps")
+	}
+}
@@ -1,8 +1,14 @@
 package shardmap
 
 import (
-	"runtime"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/cespare/xxhash"
 	"github.com/tidwall/rhh"
@@ -10,12 +16,210 @@ import (
 
 // Map is a hashmap. Like map[string]interface{}, but sharded and thread-safe.
 type Map struct {
-	init   sync.Once
-	cap    int
-	shards int
-	seed   uint32
-	mus    []sync.RWMutex
-	maps   []*rhh.Map
+	init     sync.Once
+	cap      int
+	shards   int
+	seed     uint32
+	newShard func(cap int) ShardMap
+	compress Compressor
+	compMin  int
+	compFail *compressFail
+	intern   bool
+	indexed  bool
+	tracked  bool
+	wal      *wal
+	codec    codec
+	snapMu   sync.Mutex
+	snap     *snapshotter
+	prof     *lockProfile
+	slow     *slowOps
+	viewMu   sync.Mutex
+	view     atomic.Value // *ReadView
+	stale    time.Duration
+	viewBusy int32
+	queueLen int
+	sweepInt time.Duration
+	sweep    *sweeper
+	clock    Clock
+	onExpire func(key string, value interface{})
+	maxCost  int64
+	cost     int64 // total cost of all shards, updated atomically
+	queues   []chan *writeOp
+	qmus     []sync.RWMutex // keeps Close from closing a queue during a send
+	writers  sync.WaitGroup
+	mus      []sync.RWMutex
+	maps     []ShardMap
+	index    []*keyIndex
+	vers     []uint64 // incremented on every change to a shard
+	metas    []map[string]Meta
+	expires  []map[string]expiry // for keys with a TTL
+	costs    []map[string]int64  // key -> cost, for keys with a cost
+	totals   []int64             // cost per shard
+	pins     []map[string]bool
+	rndMu    sync.Mutex
+	rnd      *rand.Rand                  // seeded source for PopAny and Sample
+	sched    func(shard int, write bool) // test hook, called before locking
+	spill    *spiller
+	store    Store
+	spillLen int
+	cache    *cache
+	grace    time.Duration
+	jump     bool
+	closeMu  sync.Mutex
+	closed   int32          // atomic
+	bg       sync.WaitGroup // background reloads
+	seqlock  bool
+	seqs     []seqLock
+	hasTTL   int32 // atomic, set once any key has had a TTL
+}
+
+// ShardMap is the hashmap that backs each shard. Implementations do not need
+// to be thread-safe, the shard locks take care of that. Set should keep the
+// existing key when replacing a value.
+type ShardMap interface {
+	Set(key string, value interface{}) (prev interface{}, replaced bool)
+	Get(key string) (value interface{}, ok bool)
+	Delete(key string) (prev interface{}, deleted bool)
+	Len() int
+	Range(iter func(key string, value interface{}) bool)
+}
+
+// Options for NewOptions.
+type Options struct {
+	// Cap is the minimum capacity.
+	Cap int
+	// NewShardMap returns the hashmap for a single shard, such as NewSwiss.
+	// The default is a robinhood hashmap from github.com/tidwall/rhh.
+	NewShardMap func(cap int) ShardMap
+	// InternKeys makes the map store its own copy of each new key, rather
+	// than the string that was passed to Set. This keeps the map from
+	// retaining larger buffers that the key may have been sliced from, such
+	// as network reads, and ensures that equal keys share one copy, which is
+	// also what Range hands back.
+	InternKeys bool
+	// Log, when set, receives every change to the map as it happens. The map
+	// can be rebuilt from the log using LoadFromLog.
+	Log io.Writer
+	// Marshal encodes values for Log and Save. The default supports string
+	// and []byte values.
+	Marshal func(value interface{}) ([]byte, error)
+	// Unmarshal decodes values that were encoded by Marshal.
+	Unmarshal func(data []byte) (interface{}, error)
+	// ProfileLocks records how long each shard lock is waited on and held,
+	// which is reported by ContentionReport. This adds a little overhead to
+	// every operation.
+	ProfileLocks bool
+	// IndexKeys keeps the keys of each shard in a sorted index alongside the
+	// hashmap, which lets RangePrefix visit only the matching keys rather
+	// than scanning the whole map. This costs extra memory, and makes adding
+	// and deleting keys slower.
+	IndexKeys bool
+	// ViewStaleness is how old the view returned by ReadView may get before
+	// it's refreshed. The default of zero only refreshes on RefreshView.
+	ViewStaleness time.Duration
+	// WriteQueue, when greater than zero, hands Set and Delete over to a
+	// writer goroutine per shard, through a queue of this length. Each writer
+	// applies all of the operations that are waiting in its queue under a
+	// single lock acquisition, which can beat contending for the lock when
+	// many goroutines write to the same shards. Other writes, such as
+	// SetAccept, bypass the queues. This also turns on SeqLockReads, and Get
+	// reads the shard tables without locking them, even while a writer is
+	// applying a batch, with the same exceptions as SeqLockReads.
+	WriteQueue int
+	// TrackMeta records a version and the created and updated times of every
+	// entry, which are returned by GetMeta.
+	TrackMeta bool
+	// SweepInterval, when set, deletes expired entries in the background at
+	// this interval. See SetTTL.
+	SweepInterval time.Duration
+	// Clock is the source of time for expirations and metadata. The default
+	// is the system clock.
+	Clock Clock
+	// OnExpire is called for every entry that's deleted because it expired,
+	// but not for entries that are deleted by Delete. It's called while the
+	// entry's shard is write locked, and thus must not access the map.
+	OnExpire func(key string, value interface{})
+	// MaxCost, when greater than zero, is the highest total cost that the
+	// entries may add up to. See SetWithCost.
+	MaxCost int64
+	// Shards is the number of shards, rounded up to a power of two. The
+	// default depends on the number of CPUs.
+	Shards int
+	// Seed, when not zero, seeds the random choices made by PopAny and
+	// Sample. Together with Shards this makes runs repeatable, which helps
+	// with fuzzing and with reproducing failures.
+	Seed int64
+	// Compressor, when set, compresses string and []byte values that are at
+	// least CompressMin bytes long. Values are decompressed whenever they're
+	// read, including the previous values returned by Set and Delete, so this
+	// trades CPU for memory. A value that fails to decompress is treated as
+	// missing, and the error is returned by Err.
+	Compressor Compressor
+	// CompressMin is the smallest value size that's compressed. The default
+	// is 1024 bytes.
+	CompressMin int
+	// Spill, when set, is where the coldest entries are moved to once there
+	// are more than SpillLen entries in memory. Spilled entries are moved
+	// back into memory when they're accessed by key, and are included by
+	// Len, Drain, Save and Compact, but not by Range and the other functions
+	// that scan the map.
+	// Values are encoded using Marshal and Unmarshal.
+	Spill Store
+	// SpillLen is the number of entries that are kept in memory when Spill
+	// is set. It's divided evenly over the shards.
+	SpillLen int
+	// Loader, when set, is called by Get for keys that are not in the map.
+	// Values that it finds are stored in the map, without being passed on to
+	// the Writer.
+	Loader Loader
+	// Writer, when set, receives every key that's set or deleted, including
+	// by PopAny and the like, but not Clear, expirations, or spilling. It's
+	// called while the key's shard is write locked, and thus must not access
+	// the map, unless WriteBehind is set.
+	Writer Writer
+	// WriteBehind, when greater than zero, hands the changes for the Writer
+	// over to a background goroutine through a queue of this length, rather
+	// than writing them before Set and Delete return. Writes block when the
+	// queue is full. Use Flush to wait for the queue.
+	WriteBehind int
+	// StaleWhileRevalidate is how long expired entries are kept and still
+	// returned, as stale values, after their TTL has passed. A Get of a
+	// stale entry has the Loader reload it in the background, only once at
+	// a time, and the reloaded value is assigned with the same TTL. Thus
+	// popular keys are refreshed without Get ever waiting for the Loader.
+	StaleWhileRevalidate time.Duration
+	// JumpHash picks the shard of each key using JumpHash, rather than the
+	// key hash modulo the number of shards. This costs a little more per
+	// operation, but when the number of shards changes, only a small part of
+	// the keys end up in another shard.
+	JumpHash bool
+	// SeqLockReads is an experimental mode where Get reads shards without
+	// locking them. Every shard has a sequence number that writers bump, and
+	// readers retry when it changed while they were reading, which avoids
+	// the shared memory writes of a read lock, and scales better with many
+	// cores reading the same shards. This replaces NewShardMap with a table
+	// that can be read while it's being written to, which is slower to write
+	// and uses more memory. Gets of maps with a Compressor or Spill, and of
+	// maps that have had entries with a TTL, still take the read lock. This
+	// is always on with WriteQueue.
+	SeqLockReads bool
+	// SlowOpThreshold is how long an operation may hold a shard lock before
+	// it's reported to OnSlowOp. This includes the time spent in callbacks
+	// that are called under the lock, such as the "accept" function of
+	// SetAccept and the iterator of Range.
+	SlowOpThreshold time.Duration
+	// OnSlowOp, when set, is called for every operation that held a shard
+	// lock for longer than SlowOpThreshold. It's called right after the lock
+	// is released, but possibly while other shards are still locked by the
+	// same operation, and thus must not access the map. Timing the locks
+	// adds a little overhead to every operation.
+	OnSlowOp func(op OpInfo)
+}
+
+// Entry is a key/value pair.
+type Entry struct {
+	Key   string
+	Value interface{}
 }
 
 // New returns a new hashmap with the specified capacity. This function is only
@@ -25,13 +229,88 @@ func New(cap int) *Map {
 	return &Map{cap: cap}
 }
 
+// NewOptions returns a new hashmap using the provided options.
+func NewOptions(opts *Options) *Map {
+	m := new(Map)
+	if opts != nil {
+		m.cap = opts.Cap
+		m.newShard = opts.NewShardMap
+		m.intern = opts.InternKeys
+		m.indexed = opts.IndexKeys
+		m.tracked = opts.TrackMeta
+		m.stale = opts.ViewStaleness
+		m.queueLen = opts.WriteQueue
+		m.sweepInt = opts.SweepInterval
+		m.clock = opts.Clock
+		m.onExpire = opts.OnExpire
+		m.maxCost = opts.MaxCost
+		m.grace = opts.StaleWhileRevalidate
+		m.jump = opts.JumpHash
+		m.seqlock = opts.SeqLockReads
+		if opts.Shards > 0 {
+			m.shards = 1
+			for m.shards < opts.Shards {
+				m.shards *= 2
+			}
+		}
+		if opts.Compressor != nil {
+			m.compress = opts.Compressor
+			m.compMin = opts.CompressMin
+			if m.compMin <= 0 {
+				m.compMin = 1024
+			}
+		}
+		m.store = opts.Spill
+		m.spillLen = opts.SpillLen
+		if opts.Loader != nil || opts.Writer != nil {
+			m.cache = &cache{
+				loader: opts.Loader,
+				writer: opts.Writer,
+				calls:  make(map[string]*loadCall),
+			}
+			if opts.Writer != nil && opts.WriteBehind > 0 {
+				m.cache.queue = make(chan cacheOp, opts.WriteBehind)
+				m.cache.done = make(chan struct{})
+			}
+		}
+		if opts.Seed != 0 {
+			m.rnd = rand.New(rand.NewSource(opts.Seed))
+		}
+		m.codec = codec{opts.Marshal, opts.Unmarshal}
+		if opts.Log != nil {
+			m.wal = &wal{w: opts.Log}
+		}
+		if opts.ProfileLocks {
+			m.prof = &lockProfile{start: time.Now()}
+		}
+		if opts.OnSlowOp != nil {
+			m.slow = &slowOps{
+				start:     time.Now(),
+				threshold: int64(opts.SlowOpThreshold),
+				fn:        opts.OnSlowOp,
+			}
+		}
+	}
+	return m
+}
+
 // Clear out all values from map
 func (m *Map) Clear() {
 	m.initDo()
+	if m.wal != nil {
+		// lock all shards so that the clear is a single log entry
+		ts := m.lockAll()
+		m.wal.clear()
+		for i := 0; i < m.shards; i++ {
+			m.reset(i)
+		}
+		m.unlockAll(ts, "Clear")
+		return
+	}
 	for i := 0; i < m.shards; i++ {
-		m.mus[i].Lock()
-		m.maps[i] = rhh.New(m.cap / m.shards)
-		m.mus[i].Unlock()
+		t := m.lock(i)
+		m.reset(i)
+		m.unlock(i, t)
 	}
 }
 
@@ -40,16 +319,22 @@ func (m *Map) Clear() {
 func (m *Map) Set(key string, value interface{}) (prev interface{}, replaced bool) {
 	m.initDo()
 	shard := m.choose(key)
-	m.mus[shard].Lock()
-	prev, replaced = m.maps[shard].Set(key, value)
-	m.mus[shard].Unlock()
+	if m.queues != nil {
+		return m.enqueue(shard, key, value, false)
+	}
+	t := m.lock(shard)
+	prev, replaced = m.set(shard, key, value)
+	m.unlockOp(shard, t, "Set", key)
 	return prev, replaced
 }
 
 // SetAccept assigns a value to a key. The "accept" function can be used to
-// inspect the previous value, if any, and accept or reject the change.
+// inspect the previous value, if any, and accept or reject the change. It's
+// called before anything is changed, thus a rejected change is never seen by
+// the log, the Writer, or other goroutines.
 // It's also provides a safe way to block other others from writing to the
-// same shard while inspecting.
+// same shard while inspecting. Thus the "accept" function must not access the
+// map, or it may deadlock. Use SetAcceptUnlocked for that.
 // Returns the previous value, or false when no value was assigned.
 func (m *Map) SetAccept(
 	key string, value interface{},
@@ -57,51 +342,156 @@ func (m *Map) SetAccept(
 ) (prev interface{}, replaced bool) {
 	m.initDo()
 	shard := m.choose(key)
-	m.mus[shard].Lock()
-	defer m.mus[shard].Unlock()
-	prev, replaced = m.maps[shard].Set(key, value)
+	t := m.lock(shard)
+	defer m.unlockOp(shard, t, "SetAccept", key)
 	if accept != nil {
+		m.expireKey(shard, key)
+		m.promote(shard, key)
+		prev, replaced = m.maps[shard].Get(key)
 		if !accept(prev, replaced) {
-			// revert unaccepted change
-			if !replaced {
-				// delete the newly set data
-				m.maps[shard].Delete(key)
-			} else {
-				// reset updated data
-				m.maps[shard].Set(key, prev)
-			}
-			prev, replaced = nil, false
+			return nil, false
 		}
 	}
-	return prev, replaced
+	return m.set(shard, key, value)
 }
 
-// Get returns a value for a key.
-// Returns false when no value has been assign for key.
-func (m *Map) Get(key string) (value interface{}, ok bool) {
+// SetTransform is like SetAccept, but the "transform" function returns the
+// value that's assigned, such as the new value merged with the previous one,
+// or false to reject the change. Like with SetAccept, the "transform" function
+// must not access the map.
+// Returns the previous value, or false when no value was assigned.
+func (m *Map) SetTransform(
+	key string, value interface{},
+	transform func(value, prev interface{}, replaced bool) (
+		newValue interface{}, ok bool,
+	),
+) (prev interface{}, replaced bool) {
 	m.initDo()
 	shard := m.choose(key)
-	m.mus[shard].RLock()
-	value, ok = m.maps[shard].Get(key)
-	m.mus[shard].RUnlock()
+	t := m.lock(shard)
+	defer m.unlockOp(shard, t, "SetTransform", key)
+	m.expireKey(shard, key)
+	m.promote(shard, key)
+	prev, replaced = m.maps[shard].Get(key)
+	value, ok := transform(value, prev, replaced)
+	if !ok {
+		return nil, false
+	}
+	return m.set(shard, key, value)
+}
+
+// Get returns a value for a key, which is loaded using Options.Loader when
+// it's not in the map, or reloaded in the background when it's stale. See
+// Options.StaleWhileRevalidate.
+// Returns false when no value has been assign for key.
+func (m *Map) Get(key string) (value interface{}, ok bool) {
+	value, ok, _ = m.get(key)
 	return value, ok
 }
 
+// get is Get, and also returns the error from the Loader, if any.
+func (m *Map) get(key string) (value interface{}, ok bool, err error) {
+	m.initDo()
+	return m.getShard(m.choose(key), key)
+}
+
+// getShard is get for a key in a known shard.
+func (m *Map) getShard(shard int, key string) (
+	value interface{}, ok bool, err error,
+) {
+	if m.seqs != nil {
+		value, ok, valid := m.seqLookup(shard, key)
+		if valid && (ok || m.cache == nil) {
+			return value, ok, nil
+		}
+	}
+	t := m.rlock(shard)
+	value, ok = m.lookup(shard, key)
+	spilled := !ok && m.spilled(shard, key)
+	var e expiry
+	var stale bool
+	if ok && m.grace > 0 {
+		e, stale = m.isStale(shard, key)
+	}
+	m.runlockOp(shard, t, "Get", key)
+	if spilled {
+		value, ok = m.getSpilled(shard, key)
+	}
+	if m.cache != nil && m.cache.loader != nil && !m.isClosed() {
+		if !ok {
+			return m.load(shard, key)
+		}
+		if stale {
+			m.revalidate(shard, key, e)
+		}
+	}
+	return value, ok, nil
+}
+
 // Delete deletes a value for a key.
 // Returns the deleted value, or false when no value was assigned.
 func (m *Map) Delete(key string) (prev interface{}, deleted bool) {
 	m.initDo()
 	shard := m.choose(key)
-	m.mus[shard].Lock()
-	prev, deleted = m.maps[shard].Delete(key)
-	m.mus[shard].Unlock()
+	if m.queues != nil {
+		return m.enqueue(shard, key, nil, true)
+	}
+	t := m.lock(shard)
+	prev, deleted = m.delete(shard, key)
+	m.unlockOp(shard, t, "Delete", key)
 	return prev, deleted
 }
 
+// LoadAndDelete deletes a value for a key, like sync.Map.
+// Returns the deleted value, or false when no value was assigned.
+func (m *Map) LoadAndDelete(key string) (value interface{}, loaded bool) {
+	return m.Delete(key)
+}
+
+// ShardHint is the shard of a key, as returned by LoadAndDeleteHint.
+type ShardHint struct {
+	shard int
+}
+
+// LoadAndDeleteHint is like LoadAndDelete, but also returns a hint that can be
+// passed to SetInShard, which saves hashing the key again when it's reinserted.
+func (m *Map) LoadAndDeleteHint(key string) (
+	value interface{}, loaded bool, hint ShardHint,
+) {
+	m.initDo()
+	shard := m.choose(key)
+	if m.queues != nil {
+		value, loaded = m.enqueue(shard, key, nil, true)
+	} else {
+		t := m.lock(shard)
+		value, loaded = m.delete(shard, key)
+		m.unlockOp(shard, t, "LoadAndDeleteHint", key)
+	}
+	return value, loaded, ShardHint{shard}
+}
+
+// SetInShard is like Set, but uses the hint that LoadAndDeleteHint returned
+// for the same key and map, rather than hashing the key. A hint for another
+// key puts the entry in the wrong shard, where it can't be found.
+// Returns the previous value, or false when no value was assigned.
+func (m *Map) SetInShard(hint ShardHint, key string, value interface{}) (
+	prev interface{}, replaced bool,
+) {
+	m.initDo()
+	if m.queues != nil {
+		return m.enqueue(hint.shard, key, value, false)
+	}
+	t := m.lock(hint.shard)
+	prev, replaced = m.set(hint.shard, key, value)
+	m.unlockOp(hint.shard, t, "SetInShard", key)
+	return prev, replaced
+}
+
 // DeleteAccept deletes a value for a key. The "accept" function can be used to
 // inspect the previous value, if any, and accept or reject the change.
 // It's also provides a safe way to block other others from writing to the
-// same shard while inspecting.
+// same shard while inspecting. Thus the "accept" function must not access the
+// map, or it may deadlock. Use DeleteAcceptUnlocked for that.
 // Returns the deleted value, or false when no value was assigned.
 func (m *Map) DeleteAccept(
 	key string,
@@ -109,45 +499,122 @@ func (m *Map) DeleteAccept(
 ) (prev interface{}, deleted bool) {
 	m.initDo()
 	shard := m.choose(key)
-	m.mus[shard].Lock()
-	defer m.mus[shard].Unlock()
-	prev, deleted = m.maps[shard].Delete(key)
+	t := m.lock(shard)
+	defer m.unlockOp(shard, t, "DeleteAccept", key)
 	if accept != nil {
+		m.expireKey(shard, key)
+		m.promote(shard, key)
+		prev, deleted = m.maps[shard].Get(key)
 		if !accept(prev, deleted) {
-			// revert unaccepted change
-			if deleted {
-				// reset updated data
-				m.maps[shard].Set(key, prev)
-			}
-			prev, deleted = nil, false
+			return nil, false
 		}
 	}
+	return m.delete(shard, key)
+}
 
-	return prev, deleted
+// SetAcceptUnlocked is like SetAccept, except that the "accept" function is
+// called without holding the shard lock, thus it may access the map. The
+// change is only made when the shard has not changed since the previous value
+// was read. Otherwise the previous value is read again and "accept" is called
+// again, so it may be called more than once.
+// Returns the previous value, or false when no value was assigned.
+func (m *Map) SetAcceptUnlocked(
+	key string, value interface{},
+	accept func(prev interface{}, replaced bool) bool,
+) (prev interface{}, replaced bool) {
+	m.initDo()
+	shard := m.choose(key)
+	for {
+		prev, replaced, ver := m.getVersion(shard, key)
+		if !accept(prev, replaced) {
+			return nil, false
+		}
+		t := m.lock(shard)
+		if m.vers[shard] == ver {
+			prev, replaced = m.set(shard, key, value)
+			m.unlock(shard, t)
+			return prev, replaced
+		}
+		m.unlock(shard, t)
+	}
+}
+
+// DeleteAcceptUnlocked is like DeleteAccept, except that the "accept" function
+// is called without holding the shard lock, thus it may access the map. See
+// SetAcceptUnlocked.
+// Returns the deleted value, or false when no value was assigned.
+func (m *Map) DeleteAcceptUnlocked(
+	key string,
+	accept func(prev interface{}, replaced bool) bool,
+) (prev interface{}, deleted bool) {
+	m.initDo()
+	shard := m.choose(key)
+	for {
+		prev, deleted, ver := m.getVersion(shard, key)
+		if !accept(prev, deleted) {
+			return nil, false
+		}
+		t := m.lock(shard)
+		if m.vers[shard] == ver {
+			prev, deleted = m.delete(shard, key)
+			m.unlock(shard, t)
+			return prev, deleted
+		}
+		m.unlock(shard, t)
+	}
 }
 
-// Len returns the number of values in map.
+// getVersion returns the value for a key along with the shard's version.
+func (m *Map) getVersion(shard int, key string) (
+	value interface{}, ok bool, ver uint64,
+) {
+	t := m.rlock(shard)
+	value, ok = m.lookup(shard, key)
+	spilled := !ok && m.spilled(shard, key)
+	ver = m.vers[shard]
+	m.runlock(shard, t)
+	if spilled {
+		// promoting changes the version
+		m.getSpilled(shard, key)
+		return m.getVersion(shard, key)
+	}
+	return value, ok, ver
+}
+
+// Len returns the number of values in map, not counting expired entries that
+// have not been deleted yet. See LenExpired.
 func (m *Map) Len() int {
 	m.initDo()
 	var len int
 	for i := 0; i < m.shards; i++ {
-		m.mus[i].Lock()
-		len += m.maps[i].Len()
-		m.mus[i].Unlock()
+		t := m.lock(i)
+		len += m.shardLen(i)
+		m.unlock(i, t)
 	}
 	return len
 }
 
-// Range iterates overall all key/values.
+// shardLen returns the number of values in a locked shard, leaving out the
+// expired ones, and including the spilled ones.
+func (m *Map) shardLen(shard int) int {
+	n := m.maps[shard].Len() - m.expiredLen(shard)
+	if m.spill != nil {
+		n += m.spill.shards[shard].spilledLen()
+	}
+	return n
+}
+
+// Range iterates overall all key/values, skipping expired entries.
 // It's not safe to call or Set or Delete while ranging.
+// Use RangeSnapshot for a consistent view of the map.
 func (m *Map) Range(iter func(key string, value interface{}) bool) {
 	m.initDo()
 	var done bool
 	for i := 0; i < m.shards; i++ {
 		func() {
-			m.mus[i].RLock()
-			defer m.mus[i].RUnlock()
-			m.maps[i].Range(func(key string, value interface{}) bool {
+			t := m.rlock(i)
+			defer m.runlockOp(i, t, "Range", "")
+			m.rangeShard(i, func(key string, value interface{}) bool {
 				if !iter(key, value) {
 					done = true
 					return false
@@ -161,21 +628,851 @@ func (m *Map) Range(iter func(key string, value interface{}) bool) {
 	}
 }
 
+// Drain removes all entries and hands them off to the "fn" function.
+// Each shard is swapped out for an empty one while holding its lock, so every
+// entry is handed off exactly once, even when other goroutines keep writing
+// to the map. The "fn" function is called after the shard lock is released.
+// Expired entries are dropped rather than handed off. Spilled entries are
+// read back from the store and handed off as well. A
+// shard whose spilled entries can't be read is left as is, and the error is
+// returned by Err.
+func (m *Map) Drain(fn func(key string, value interface{})) {
+	m.initDo()
+	for i := 0; i < m.shards; i++ {
+		t := m.lock(i)
+		old := m.maps[i]
+		spilled, err := m.spilledEntries(i)
+		if err != nil {
+			m.spill.fail(err)
+			m.unlock(i, t)
+			continue
+		}
+		var dead map[string]bool
+		if len(m.expires[i]) > 0 {
+			now := m.now()
+			for key := range m.expires[i] {
+				if m.expiredAt(i, key, now) {
+					if dead == nil {
+						dead = make(map[string]bool)
+					}
+					dead[key] = true
+				}
+			}
+		}
+		// all spilled keys, including the expired ones that were skipped
+		var gone []string
+		if m.spill != nil {
+			for key := range m.spill.shards[i].spilled {
+				gone = append(gone, key)
+			}
+		}
+		if old.Len() > 0 || len(gone) > 0 {
+			m.reset(i)
+			if m.wal != nil {
+				old.Range(func(key string, value interface{}) bool {
+					m.wal.delete(key)
+					return true
+				})
+				for _, key := range gone {
+					m.wal.delete(key)
+				}
+			}
+		}
+		m.unlock(i, t)
+		old.Range(func(key string, value interface{}) bool {
+			if !dead[key] {
+				fn(key, value)
+			}
+			return true
+		})
+		for _, e := range spilled {
+			fn(e.Key, e.Value)
+		}
+	}
+}
+
+// Merge copies all entries from other into the map. When a key exists in both
+// maps, "resolve" is called with the existing value (a) and the value from
+// other (b), and its result is stored. A nil "resolve" keeps the value from
+// other. Entries are grouped by their destination shard, which is locked once
+// per group rather than once per entry.
+func (m *Map) Merge(
+	other *Map, resolve func(key string, a, b interface{}) interface{},
+) {
+	if other == m {
+		return
+	}
+	m.initDo()
+	other.initDo()
+	groups := make([][]Entry, m.shards)
+	for i := 0; i < other.shards; i++ {
+		// copy the source shard before locking any destination shard to
+		// avoid deadlocking with a concurrent merge in the other direction.
+		t := other.rlock(i)
+		other.rangeShard(i, func(key string, value interface{}) bool {
+			shard := m.choose(key)
+			groups[shard] = append(groups[shard], Entry{key, value})
+			return true
+		})
+		other.runlock(i, t)
+		m.setGroups(groups, resolve)
+	}
+}
+
+// LoadMap copies all entries from a standard Go map into the map. Entries are
+// grouped by their destination shard, which is locked once per group rather
+// than once per entry, and shards that are still empty are sized for their
+// group up front, so that bulk loads don't grow the shards along the way.
+func (m *Map) LoadMap(src map[string]interface{}) {
+	m.initDo()
+	groups := make([][]Entry, m.shards)
+	for key, value := range src {
+		shard := m.choose(key)
+		groups[shard] = append(groups[shard], Entry{key, value})
+	}
+	m.setGroups(groups, nil)
+}
+
+// setGroups assigns the grouped entries to their shards, then empties the
+// groups for reuse.
+func (m *Map) setGroups(
+	groups [][]Entry, resolve func(key string, a, b interface{}) interface{},
+) {
+	for shard, entries := range groups {
+		if len(entries) == 0 {
+			continue
+		}
+		m.setGroup(shard, entries, resolve)
+		groups[shard] = entries[:0]
+	}
+}
+
+// setGroup stores entries that all belong to the same shard.
+func (m *Map) setGroup(
+	shard int, entries []Entry,
+	resolve func(key string, a, b interface{}) interface{},
+) {
+	t := m.lock(shard)
+	if m.maps[shard].Len() == 0 && len(entries) > shardCap(m.cap, m.shards) {
+		// size an empty shard for all of its entries up front, rather
+		// than growing it along the way
+		m.setShardMap(shard, m.newShard(len(entries)))
+	}
+	for _, e := range entries {
+		if resolve != nil {
+			m.promote(shard, e.Key)
+			if prev, ok := m.maps[shard].Get(e.Key); ok {
+				e.Value = resolve(e.Key, prev, e.Value)
+			}
+		}
+		m.set(shard, e.Key, e.Value)
+	}
+	m.unlock(shard, t)
+}
+
+// PopAny removes and returns an arbitrary entry.
+// Returns false when the map is empty.
+func (m *Map) PopAny() (key string, value interface{}, ok bool) {
+	m.initDo()
+	start := m.intn(m.shards)
+	for i := 0; i < m.shards; i++ {
+		shard := (start + i) % m.shards
+		t := m.lock(shard)
+		m.rangeShard(shard, func(k string, v interface{}) bool {
+			key, value, ok = k, v, true
+			return false
+		})
+		if ok {
+			m.delete(shard, key)
+		}
+		m.unlock(shard, t)
+		if ok {
+			return key, value, true
+		}
+	}
+	return "", nil, false
+}
+
+// Sample returns up to n random entries. No entry is returned more than once
+// and the entries are not removed from the map.
+// Every entry is equally likely to be picked, and the sample has n entries,
+// or all of them when the map has fewer, unless entries are deleted while
+// it's being taken. The shards are locked one at a time, and only the shards
+// that hold a picked entry are scanned, up to the last one they hold.
+func (m *Map) Sample(n int) []Entry {
+	m.initDo()
+	if n <= 0 {
+		return nil
+	}
+	lens := make([]int, m.shards)
+	var total int
+	for i := range lens {
+		t := m.rlock(i)
+		lens[i] = m.maps[i].Len() - m.expiredLen(i)
+		m.runlock(i, t)
+		total += lens[i]
+	}
+	if n > total {
+		n = total
+	}
+	// pick n distinct positions out of all entries, using Floyd's algorithm
+	picked := make(map[int]bool, n)
+	for j := total - n; j < total; j++ {
+		if p := m.intn(j + 1); picked[p] {
+			picked[j] = true
+		} else {
+			picked[p] = true
+		}
+	}
+	pos := make([]int, 0, n)
+	for p := range picked {
+		pos = append(pos, p)
+	}
+	sort.Ints(pos)
+	entries := make([]Entry, 0, n)
+	var base int
+	for shard := 0; shard < m.shards && len(pos) > 0; shard++ {
+		end := base + lens[shard]
+		if pos[0] < end {
+			i := base
+			t := m.rlock(shard)
+			m.rangeShard(shard, func(key string, value interface{}) bool {
+				if i == pos[0] {
+					entries = append(entries, Entry{key, value})
+					if pos = pos[1:]; len(pos) == 0 || pos[0] >= end {
+						return false
+					}
+				}
+				i++
+				return true
+			})
+			m.runlock(shard, t)
+			// the positions past the end of a shard that shrank meanwhile
+			for len(pos) > 0 && pos[0] < end {
+				pos = pos[1:]
+			}
+		}
+		base = end
+	}
+	// the entries are in shard order, shuffle them
+	for i := len(entries) - 1; i > 0; i-- {
+		j := m.intn(i + 1)
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+// Filter returns a new map containing the entries for which "pred" returns
+// true. The shards are scanned in parallel, and "pred" may be called
+// concurrently from multiple goroutines.
+func (m *Map) Filter(pred func(key string, value interface{}) bool) *Map {
+	m.initDo()
+	out := &Map{
+		cap: m.cap, shards: m.shards, newShard: m.newShard, intern: m.intern,
+		indexed: m.indexed, tracked: m.tracked, codec: m.codec,
+	}
+	m.parallel(func(shard int) {
+		t := m.rlock(shard)
+		m.rangeShard(shard, func(key string, value interface{}) bool {
+			if pred(key, value) {
+				out.Set(key, value)
+			}
+			return true
+		})
+		m.runlockOp(shard, t, "Filter", "")
+	})
+	return out
+}
+
+// Count returns the number of entries for which "pred" returns true. The
+// shards are scanned in parallel, and "pred" may be called concurrently from
+// multiple goroutines.
+func (m *Map) Count(pred func(key string, value interface{}) bool) int {
+	m.initDo()
+	var count int64
+	m.parallel(func(shard int) {
+		var n int64
+		t := m.rlock(shard)
+		m.rangeShard(shard, func(key string, value interface{}) bool {
+			if pred(key, value) {
+				n++
+			}
+			return true
+		})
+		m.runlockOp(shard, t, "Count", "")
+		atomic.AddInt64(&count, n)
+	})
+	return int(count)
+}
+
+// FindKeys returns the keys of up to "limit" entries for which "pred" returns
+// true, or of all such entries when "limit" is zero or less. The shards are
+// scanned in parallel, and the scan stops once the limit has been reached.
+// "pred" may be called concurrently from multiple goroutines.
+func (m *Map) FindKeys(pred func(value interface{}) bool, limit int) []string {
+	m.initDo()
+	var mu sync.Mutex
+	var keys []string
+	var full int32
+	m.parallel(func(shard int) {
+		if atomic.LoadInt32(&full) != 0 {
+			return
+		}
+		var found []string
+		t := m.rlock(shard)
+		m.rangeShard(shard, func(key string, value interface{}) bool {
+			if pred(value) {
+				found = append(found, key)
+				if limit > 0 && len(found) >= limit {
+					return false
+				}
+			}
+			return atomic.LoadInt32(&full) == 0
+		})
+		m.runlockOp(shard, t, "FindKeys", "")
+		if len(found) == 0 {
+			return
+		}
+		mu.Lock()
+		keys = append(keys, found...)
+		if limit > 0 && len(keys) >= limit {
+			keys = keys[:limit]
+			atomic.StoreInt32(&full, 1)
+		}
+		mu.Unlock()
+	})
+	return keys
+}
+
+// Reduce folds all entries into a single value. Each shard is folded in
+// parallel using "fn", starting from "initial", and then the per-shard results
+// are combined using "merge". Thus "initial" should be an identity value for
+// "merge", such as zero for a sum. Both functions may be called concurrently
+// from multiple goroutines.
+func (m *Map) Reduce(
+	fn func(acc interface{}, key string, value interface{}) interface{},
+	merge func(a, b interface{}) interface{},
+	initial interface{},
+) interface{} {
+	m.initDo()
+	var mu sync.Mutex
+	result := initial
+	m.parallel(func(shard int) {
+		acc := initial
+		t := m.rlock(shard)
+		m.rangeShard(shard, func(key string, value interface{}) bool {
+			acc = fn(acc, key, value)
+			return true
+		})
+		m.runlockOp(shard, t, "Reduce", "")
+		mu.Lock()
+		result = merge(result, acc)
+		mu.Unlock()
+	})
+	return result
+}
+
+// Equal returns true when both maps contain the same keys, and "eq" returns
+// true for the values of every key. A nil "eq" compares values using ==.
+// The shards are compared in parallel and the comparison stops at the first
+// difference. Spilled entries are read back from the store and compared too,
+// and a shard whose spilled entries can't be read counts as a difference. The
+// result is only meaningful when neither map is being written to during the
+// call.
+func (m *Map) Equal(other *Map, eq func(a, b interface{}) bool) bool {
+	if other == m {
+		return true
+	}
+	if m.Len() != other.Len() {
+		return false
+	}
+	if eq == nil {
+		eq = func(a, b interface{}) bool { return a == b }
+	}
+	var diff int32
+	m.parallel(func(shard int) {
+		if atomic.LoadInt32(&diff) != 0 {
+			return
+		}
+		// copy the shard so that no lock is held while reading other
+		var entries []Entry
+		t := m.rlock(shard)
+		m.rangeShard(shard, func(key string, value interface{}) bool {
+			entries = append(entries, Entry{key, value})
+			return true
+		})
+		spilled, err := m.spilledEntries(shard)
+		m.runlock(shard, t)
+		if err != nil {
+			m.spill.fail(err)
+			atomic.StoreInt32(&diff, 1)
+			return
+		}
+		for _, e := range append(entries, spilled...) {
+			if atomic.LoadInt32(&diff) != 0 {
+				return
+			}
+			value, ok := other.Get(e.Key)
+			if !ok || !eq(e.Value, value) {
+				atomic.StoreInt32(&diff, 1)
+				return
+			}
+		}
+	})
+	return diff == 0
+}
+
+// parallel calls "fn" for every shard, using up to numWorkers goroutines, or
+// none when it's just one.
+func (m *Map) parallel(fn func(shard int)) {
+	workers := numWorkers()
+	if workers > m.shards {
+		workers = m.shards
+	}
+	if workers <= 1 {
+		for shard := 0; shard < m.shards; shard++ {
+			fn(shard)
+		}
+		return
+	}
+	var next int64 = -1
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				shard := int(atomic.AddInt64(&next, 1))
+				if shard >= m.shards {
+					return
+				}
+				fn(shard)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// RangeSnapshot iterates over a point-in-time copy of all key/values.
+// All shards are read locked together while the copy is made, so the
+// iteration does not observe writes that happen during the call. Every key
+// that is present for the whole call is visited exactly once. It's safe to
+// call Set or Delete while ranging.
+func (m *Map) RangeSnapshot(iter func(key string, value interface{}) bool) {
+	m.initDo()
+	ts := m.rlockAll()
+	var n int
+	for i := 0; i < m.shards; i++ {
+		n += m.maps[i].Len()
+	}
+	entries := make([]Entry, 0, n)
+	for i := 0; i < m.shards; i++ {
+		m.rangeShard(i, func(key string, value interface{}) bool {
+			entries = append(entries, Entry{key, value})
+			return true
+		})
+		m.runlockOp(i, ts[i], "RangeSnapshot", "")
+	}
+	for _, e := range entries {
+		if !iter(e.Key, e.Value) {
+			return
+		}
+	}
+}
+
+// set assigns a value to a key in a write locked shard.
+func (m *Map) set(shard int, key string, value interface{}) (
+	prev interface{}, replaced bool,
+) {
+	prev, replaced = m.fill(shard, key, value)
+	if m.cache != nil && m.cache.writer != nil && !m.isClosed() {
+		m.cache.write(key, value, false)
+	}
+	return prev, replaced
+}
+
+// fill assigns a value to a key in a write locked shard, without passing it
+// on to the Writer.
+func (m *Map) fill(shard int, key string, value interface{}) (
+	prev interface{}, replaced bool,
+) {
+	m.expireKey(shard, key)
+	prev, replaced = m.put(shard, key, value)
+	if m.wal != nil {
+		m.wal.set(m.codec, key, value)
+	}
+	return prev, replaced
+}
+
+// delete deletes a value for a key in a write locked shard.
+func (m *Map) delete(shard int, key string) (prev interface{}, deleted bool) {
+	m.expireKey(shard, key)
+	prev, deleted = m.remove(shard, key)
+	if deleted && m.wal != nil {
+		m.wal.delete(key)
+	}
+	if deleted && m.cache != nil && m.cache.writer != nil && !m.isClosed() {
+		m.cache.write(key, nil, true)
+	}
+	return prev, deleted
+}
+
+// put assigns a value to a key in a write locked shard, without logging.
+func (m *Map) put(shard int, key string, value interface{}) (
+	prev interface{}, replaced bool,
+) {
+	m.promote(shard, key)
+	if m.intern {
+		// Existing keys are kept by the shard map, so only new keys need to
+		// be copied.
+		if _, ok := m.maps[shard].Get(key); !ok {
+			key = cloneString(key)
+		}
+	}
+	prev, replaced = m.maps[shard].Set(key, value)
+	m.vers[shard]++
+	if !replaced && m.index != nil {
+		m.index[shard].insert(key)
+	}
+	if m.metas != nil {
+		m.putMeta(shard, key, replaced)
+	}
+	if len(m.expires[shard]) > 0 {
+		delete(m.expires[shard], key)
+	}
+	if len(m.costs[shard]) > 0 {
+		m.removeCost(shard, key)
+	}
+	if !replaced && m.spill != nil {
+		m.added(shard, key)
+	}
+	return prev, replaced
+}
+
+// replace is set, except that an existing key keeps its expiration and cost,
+// if any.
+func (m *Map) replace(shard int, key string, value interface{}) (
+	prev interface{}, replaced bool,
+) {
+	m.expireKey(shard, key)
+	e, ttl := m.expires[shard][key]
+	cost, costly := m.costs[shard][key]
+	if costly {
+		// keep the cost out of reach of set, rather than dropping it and
+		// adding it back, which would let other shards take its room
+		delete(m.costs[shard], key)
+	}
+	prev, replaced = m.set(shard, key, value)
+	if ttl {
+		m.setExpiry(shard, key, e)
+	}
+	if costly {
+		m.costs[shard][key] = cost
+	}
+	return prev, replaced
+}
+
+// remove deletes a value for a key in a write locked shard, without logging.
+func (m *Map) remove(shard int, key string) (prev interface{}, deleted bool) {
+	m.promote(shard, key)
+	prev, deleted = m.maps[shard].Delete(key)
+	if deleted {
+		m.vers[shard]++
+		if m.index != nil {
+			m.index[shard].remove(key)
+		}
+		if m.metas != nil {
+			delete(m.metas[shard], key)
+		}
+		if len(m.expires[shard]) > 0 {
+			delete(m.expires[shard], key)
+		}
+		if len(m.costs[shard]) > 0 {
+			m.removeCost(shard, key)
+		}
+		if len(m.pins[shard]) > 0 {
+			delete(m.pins[shard], key)
+		}
+		if m.spill != nil {
+			m.removed(shard, key)
+		}
+	}
+	return prev, deleted
+}
+
+// reset replaces a write locked shard with an empty one.
+func (m *Map) reset(shard int) {
+	m.setShardMap(shard, m.newShard(shardCap(m.cap, m.shards)))
+	m.vers[shard]++
+	if m.index != nil {
+		m.index[shard] = newKeyIndex()
+	}
+	if m.metas != nil {
+		m.metas[shard] = make(map[string]Meta)
+	}
+	m.expires[shard] = nil
+	m.costs[shard] = nil
+	atomic.AddInt64(&m.cost, -m.totals[shard])
+	m.totals[shard] = 0
+	m.pins[shard] = nil
+	if m.spill != nil {
+		m.resetSpill(shard)
+	}
+}
+
+func cloneString(s string) string {
+	var b strings.Builder
+	b.WriteString(s)
+	return b.String()
+}
+
+// lock write locks a shard. Returns the time that the lock was acquired when
+// profiling, which must be passed on to unlock.
+func (m *Map) lock(shard int) int64 {
+	if m.sched != nil {
+		m.sched(shard, true)
+	}
+	if !m.timed() {
+		m.mus[shard].Lock()
+		m.seqWrite(shard)
+		return 0
+	}
+	start := m.lockTime()
+	m.mus[shard].Lock()
+	m.seqWrite(shard)
+	t := m.lockTime()
+	if m.prof != nil {
+		m.prof.acquired(shard, true, t-start)
+	}
+	return t
+}
+func (m *Map) unlock(shard int, t int64) {
+	m.unlockOp(shard, t, "", "")
+}
+
+// unlockOp is unlock for a named operation, which is reported when it held
+// the lock for too long. See Options.SlowOpThreshold.
+func (m *Map) unlockOp(shard int, t int64, op, key string) {
+	if !m.timed() {
+		m.seqWrite(shard)
+		m.mus[shard].Unlock()
+		return
+	}
+	held := m.lockTime() - t
+	if m.prof != nil {
+		m.prof.released(shard, true, held)
+	}
+	m.seqWrite(shard)
+	m.mus[shard].Unlock()
+	m.slowOp(shard, true, held, op, key)
+}
+func (m *Map) rlock(shard int) int64 {
+	if m.sched != nil {
+		m.sched(shard, false)
+	}
+	if !m.timed() {
+		m.mus[shard].RLock()
+		return 0
+	}
+	start := m.lockTime()
+	m.mus[shard].RLock()
+	t := m.lockTime()
+	if m.prof != nil {
+		m.prof.acquired(shard, false, t-start)
+	}
+	return t
+}
+func (m *Map) runlock(shard int, t int64) {
+	m.runlockOp(shard, t, "", "")
+}
+
+// runlockOp is runlock for a named operation.
+func (m *Map) runlockOp(shard int, t int64, op, key string) {
+	if !m.timed() {
+		m.mus[shard].RUnlock()
+		return
+	}
+	held := m.lockTime() - t
+	if m.prof != nil {
+		m.prof.released(shard, false, held)
+	}
+	m.mus[shard].RUnlock()
+	m.slowOp(shard, false, held, op, key)
+}
+
+// lockAll write locks every shard, in order. Returns the time that each
+// shard was locked, which must be passed on to unlockAll.
+func (m *Map) lockAll() []int64 {
+	ts := make([]int64, m.shards)
+	for i := 0; i < m.shards; i++ {
+		ts[i] = m.lock(i)
+	}
+	return ts
+}
+
+func (m *Map) unlockAll(ts []int64, op string) {
+	for i := 0; i < m.shards; i++ {
+		m.unlockOp(i, ts[i], op, "")
+	}
+}
+
+// rlockAll read locks every shard, in order. Returns the time that each
+// shard was locked, which must be passed on to runlockAll.
+func (m *Map) rlockAll() []int64 {
+	ts := make([]int64, m.shards)
+	for i := 0; i < m.shards; i++ {
+		ts[i] = m.rlock(i)
+	}
+	return ts
+}
+
+func (m *Map) runlockAll(ts []int64, op string) {
+	for i := 0; i < m.shards; i++ {
+		m.runlockOp(i, ts[i], op, "")
+	}
+}
+
+// intn returns a random number in [0,n), using the seeded source when the map
+// has one.
+func (m *Map) intn(n int) int {
+	if m.rnd == nil {
+		return rand.Intn(n)
+	}
+	m.rndMu.Lock()
+	defer m.rndMu.Unlock()
+	return m.rnd.Intn(n)
+}
+
 func (m *Map) choose(key string) int {
-	return int(xxhash.Sum64String(key) & uint64(m.shards-1))
+	if m.jump {
+		return JumpHash(Hash(key), m.shards)
+	}
+	return int(Hash(key) & uint64(m.shards-1))
+}
+
+// Hash returns the hash of a key, which is the 64-bit xxHash of the key
+// bytes. This is part of the API and will not change.
+func Hash(key string) uint64 {
+	return xxhash.Sum64String(key)
+}
+
+// Hash128 returns a 128-bit hash of a key. The high half is the same as Hash,
+// and the low half is an FNV-1a hash of the key bytes, with a final mix so
+// that every bit depends on every byte. This is part of the API and will not
+// change.
+func Hash128(key string) (hi, lo uint64) {
+	lo = 14695981039346656037
+	for i := 0; i < len(key); i++ {
+		lo ^= uint64(key[i])
+		lo *= 1099511628211
+	}
+	return Hash(key), mix64(lo)
+}
+
+// Hash returns the hash of a key. See the Hash function.
+func (m *Map) Hash(key string) uint64 {
+	return Hash(key)
+}
+
+// Shard returns the index of the shard that holds a key, which is the key
+// hash modulo NumShards, or JumpHash of the key hash with Options.JumpHash.
+// Keys that are in the same shard share a lock, thus work that is partitioned
+// by shard, such as assigning keys to worker goroutines, will not contend
+// across partitions.
+func (m *Map) Shard(key string) int {
+	m.initDo()
+	return m.choose(key)
+}
+
+// NumShards returns the number of shards, which is always a power of two.
+func (m *Map) NumShards() int {
+	m.initDo()
+	return m.shards
 }
 
 func (m *Map) initDo() {
 	m.init.Do(func() {
-		m.shards = 1
-		for m.shards < runtime.NumCPU()*16 {
-			m.shards *= 2
+		if m.shards == 0 {
+			m.shards = numShards()
+		}
+		if m.newShard == nil {
+			m.newShard = newRHH
+		}
+		if m.seqlock || m.queueLen > 0 {
+			m.newShard = newSeqShard
+			if m.compress == nil && m.store == nil {
+				m.seqs = make([]seqLock, m.shards)
+			}
+		}
+		if m.compress != nil {
+			newShard := m.newShard
+			m.compFail = new(compressFail)
+			m.newShard = func(cap int) ShardMap {
+				return &compressedShard{
+					newShard(cap), m.compress, m.compMin, m.compFail,
+				}
+			}
+		}
+		scap := shardCap(m.cap, m.shards)
+		if m.prof != nil {
+			m.prof.shards = make([]shardProfile, m.shards)
 		}
-		scap := m.cap / m.shards
 		m.mus = make([]sync.RWMutex, m.shards)
-		m.maps = make([]*rhh.Map, m.shards)
+		m.maps = make([]ShardMap, m.shards)
+		m.vers = make([]uint64, m.shards)
+		m.expires = make([]map[string]expiry, m.shards)
+		m.costs = make([]map[string]int64, m.shards)
+		m.totals = make([]int64, m.shards)
+		m.pins = make([]map[string]bool, m.shards)
 		for i := 0; i < len(m.maps); i++ {
-			m.maps[i] = rhh.New(scap)
+			m.setShardMap(i, m.newShard(scap))
+		}
+		if m.indexed {
+			m.index = make([]*keyIndex, m.shards)
+			for i := range m.index {
+				m.index[i] = newKeyIndex()
+			}
+		}
+		if m.tracked {
+			m.metas = make([]map[string]Meta, m.shards)
+			for i := range m.metas {
+				m.metas[i] = make(map[string]Meta, scap)
+			}
+		}
+		if m.queueLen > 0 {
+			m.queues = make([]chan *writeOp, m.shards)
+			m.qmus = make([]sync.RWMutex, m.shards)
+			m.writers.Add(m.shards)
+			for i := range m.queues {
+				m.queues[i] = make(chan *writeOp, m.queueLen)
+				go m.writer(i, m.queues[i])
+			}
+		}
+		if m.store != nil {
+			m.spill = newSpiller(m.store, m.spillLen, m.shards)
+		}
+		if m.sweepInt > 0 {
+			m.startSweeper(m.sweepInt)
+		}
+		if m.cache != nil && m.cache.queue != nil {
+			go m.cache.run()
 		}
 	})
 }
+
+func newRHH(cap int) ShardMap {
+	return rhh.New(cap)
+}
+
+// shardCap returns the capacity of each shard for a map with a total capacity
+// of cap. Hashing spreads the keys over the shards binomially, so rather than
+// an even share, each shard gets room for three standard deviations more,
+// which keeps all but about one shard in a thousand from growing when cap
+// keys are added.
+func shardCap(cap, shards int) int {
+	if cap <= 0 {
+		return 0
+	}
+	mean := float64(cap) / float64(shards)
+	return int(math.Ceil(mean + 3*math.Sqrt(mean)))
+}
@@ -7,9 +7,14 @@ package shardmap
 import (
 	"fmt"
 	"math/rand"
+	"reflect"
+	"runtime"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unsafe"
 )
 
 type keyT = string
@@ -312,3 +317,508 @@ func TestClear(t *testing.T) {
 	}
 
 }
+
+func TestPopAny(t *testing.T) {
+	var m Map
+	if _, _, ok := m.PopAny(); ok {
+		t.Fatal("expected false")
+	}
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprintf("%d", i), i)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		key, value, ok := m.PopAny()
+		if !ok {
+			t.Fatal("expected true")
+		}
+		if key != fmt.Sprintf("%d", value) {
+			t.Fatalf("expected '%v', got '%v'", value, key)
+		}
+		if seen[key] {
+			t.Fatalf("duplicate key '%v'", key)
+		}
+		seen[key] = true
+	}
+	if m.Len() != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, m.Len())
+	}
+	if _, _, ok := m.PopAny(); ok {
+		t.Fatal("expected false")
+	}
+}
+
+func TestSample(t *testing.T) {
+	var m Map
+	if len(m.Sample(10)) != 0 {
+		t.Fatal("expected empty sample")
+	}
+	for i := 0; i < 10; i++ {
+		m.Set(fmt.Sprintf("%d", i), i)
+	}
+	if len(m.Sample(4)) != 4 {
+		t.Fatal("expected sample of 4")
+	}
+	for i := 10; i < 1000; i++ {
+		m.Set(fmt.Sprintf("%d", i), i)
+	}
+	for _, n := range []int{0, 1, 10, 499, 500, 999, 1000, 2000} {
+		entries := m.Sample(n)
+		exp := n
+		if exp > 1000 {
+			exp = 1000
+		}
+		if len(entries) != exp {
+			t.Fatalf("expected '%v', got '%v'", exp, len(entries))
+		}
+		seen := make(map[string]bool)
+		for _, e := range entries {
+			if e.Key != fmt.Sprintf("%d", e.Value) {
+				t.Fatalf("expected '%v', got '%v'", e.Value, e.Key)
+			}
+			if seen[e.Key] {
+				t.Fatalf("duplicate key '%v'", e.Key)
+			}
+			seen[e.Key] = true
+		}
+	}
+	if m.Len() != 1000 {
+		t.Fatalf("expected '%v', got '%v'", 1000, m.Len())
+	}
+}
+
+func TestSampleUniform(t *testing.T) {
+	// few keys over many shards, thus most shards are empty
+	m := NewOptions(&Options{Shards: 64, Seed: 1})
+	for i := 0; i < 10; i++ {
+		m.Set(k(i), i)
+	}
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		entries := m.Sample(3)
+		if len(entries) != 3 {
+			t.Fatalf("expected '%v', got '%v'", 3, len(entries))
+		}
+		for _, e := range entries {
+			counts[e.Key]++
+		}
+	}
+	for i := 0; i < 10; i++ {
+		// 3000 each on average
+		if n := counts[k(i)]; n < 2700 || n > 3300 {
+			t.Fatalf("expected about '%v', got '%v'", 3000, n)
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	var m Map
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprintf("%d", i), i)
+	}
+	seen := make(map[string]bool)
+	m.Drain(func(key string, value interface{}) {
+		if key != fmt.Sprintf("%d", value) {
+			t.Fatalf("expected '%v', got '%v'", value, key)
+		}
+		if seen[key] {
+			t.Fatalf("duplicate key '%v'", key)
+		}
+		seen[key] = true
+		// accessing the map from the callback must not deadlock
+		if _, ok := m.Get(key); ok {
+			t.Fatalf("expected '%v' to be removed", key)
+		}
+	})
+	if len(seen) != 1000 {
+		t.Fatalf("expected '%v', got '%v'", 1000, len(seen))
+	}
+	if m.Len() != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, m.Len())
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	var m Map
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprintf("%d", i), i)
+	}
+	even := func(key string, value interface{}) bool {
+		return value.(int)%2 == 0
+	}
+	f := m.Filter(even)
+	if f.Len() != 500 {
+		t.Fatalf("expected '%v', got '%v'", 500, f.Len())
+	}
+	f.Range(func(key string, value interface{}) bool {
+		if !even(key, value) {
+			t.Fatalf("unexpected value '%v'", value)
+		}
+		return true
+	})
+	if n := m.Count(even); n != 500 {
+		t.Fatalf("expected '%v', got '%v'", 500, n)
+	}
+	sum := m.Reduce(func(acc interface{}, key string, value interface{}) interface{} {
+		return acc.(int) + value.(int)
+	}, func(a, b interface{}) interface{} {
+		return a.(int) + b.(int)
+	}, 0)
+	if sum.(int) != 999*1000/2 {
+		t.Fatalf("expected '%v', got '%v'", 999*1000/2, sum)
+	}
+	small := func(value interface{}) bool { return value.(int) < 100 }
+	keys := m.FindKeys(small, 0)
+	if len(keys) != 100 {
+		t.Fatalf("expected '%v', got '%v'", 100, len(keys))
+	}
+	for _, key := range keys {
+		if v, _ := m.Get(key); !small(v) {
+			t.Fatalf("unexpected value '%v'", v)
+		}
+	}
+	if keys := m.FindKeys(small, 10); len(keys) != 10 {
+		t.Fatalf("expected '%v', got '%v'", 10, len(keys))
+	}
+}
+
+func TestMerge(t *testing.T) {
+	var a, b Map
+	for i := 0; i < 1000; i++ {
+		a.Set(fmt.Sprintf("%d", i), i)
+	}
+	for i := 500; i < 1500; i++ {
+		b.Set(fmt.Sprintf("%d", i), i)
+	}
+	a.Merge(&b, func(key string, a, b interface{}) interface{} {
+		return a.(int) + b.(int)
+	})
+	if a.Len() != 1500 {
+		t.Fatalf("expected '%v', got '%v'", 1500, a.Len())
+	}
+	for i := 0; i < 1500; i++ {
+		v, _ := a.Get(fmt.Sprintf("%d", i))
+		exp := i
+		if i >= 500 && i < 1000 {
+			exp = i * 2
+		}
+		if v != exp {
+			t.Fatalf("expected '%v', got '%v'", exp, v)
+		}
+	}
+	a.Merge(&a, nil)
+	if a.Len() != 1500 {
+		t.Fatalf("expected '%v', got '%v'", 1500, a.Len())
+	}
+	src := make(map[string]interface{})
+	for i := 1000; i < 2000; i++ {
+		src[fmt.Sprintf("%d", i)] = -i
+	}
+	a.LoadMap(src)
+	if a.Len() != 2000 {
+		t.Fatalf("expected '%v', got '%v'", 2000, a.Len())
+	}
+	if v, _ := a.Get("1200"); v != -1200 {
+		t.Fatalf("expected '%v', got '%v'", -1200, v)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	var a, b Map
+	if !a.Equal(&b, nil) {
+		t.Fatal("expected true")
+	}
+	for i := 0; i < 1000; i++ {
+		a.Set(fmt.Sprintf("%d", i), i)
+		b.Set(fmt.Sprintf("%d", i), i)
+	}
+	if !a.Equal(&b, nil) || !b.Equal(&a, nil) {
+		t.Fatal("expected true")
+	}
+	b.Set("500", -1)
+	if a.Equal(&b, nil) {
+		t.Fatal("expected false")
+	}
+	if !a.Equal(&b, func(a, b interface{}) bool { return true }) {
+		t.Fatal("expected true")
+	}
+	b.Delete("500")
+	b.Set("extra", 500)
+	if a.Equal(&b, func(a, b interface{}) bool { return true }) {
+		t.Fatal("expected false")
+	}
+	b.Delete("extra")
+	if a.Equal(&b, nil) {
+		t.Fatal("expected false")
+	}
+}
+
+func TestRangeSnapshot(t *testing.T) {
+	var m Map
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprintf("%d", i), i)
+	}
+	seen := make(map[string]bool)
+	m.RangeSnapshot(func(key string, value interface{}) bool {
+		if seen[key] {
+			t.Fatalf("duplicate key '%v'", key)
+		}
+		seen[key] = true
+		// writing while ranging is allowed and not observed
+		m.Delete(key)
+		m.Set("new:"+key, value)
+		return true
+	})
+	if len(seen) != 1000 {
+		t.Fatalf("expected '%v', got '%v'", 1000, len(seen))
+	}
+	if m.Len() != 1000 {
+		t.Fatalf("expected '%v', got '%v'", 1000, m.Len())
+	}
+	var n int
+	m.RangeSnapshot(func(key string, value interface{}) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Fatalf("expected '%v', got '%v'", 10, n)
+	}
+}
+
+func TestInternKeys(t *testing.T) {
+	m := NewOptions(&Options{InternKeys: true})
+	buf := []byte("hello world")
+	key := string(buf)
+	m.Set(key[:5], 1)
+	m.Set(string(buf[:5]), 2)
+	prev, _ := m.SetAccept("hello", 3, nil)
+	if prev != 2 {
+		t.Fatalf("expected '%v', got '%v'", 2, prev)
+	}
+	if m.Len() != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, m.Len())
+	}
+	m.Range(func(k string, value interface{}) bool {
+		if k != "hello" {
+			t.Fatalf("expected '%v', got '%v'", "hello", k)
+		}
+		if (*reflect.StringHeader)(unsafe.Pointer(&k)).Data ==
+			(*reflect.StringHeader)(unsafe.Pointer(&key)).Data {
+			t.Fatal("expected a copy of the key")
+		}
+		return true
+	})
+}
+
+func TestShard(t *testing.T) {
+	var m Map
+	n := m.NumShards()
+	if n == 0 || n&(n-1) != 0 {
+		t.Fatalf("expected power of two, got '%v'", n)
+	}
+	if Hash("hello") != 0x26c7827d889f6da3 {
+		t.Fatalf("expected '%x', got '%x'", 0x26c7827d889f6da3, Hash("hello"))
+	}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("%d", i)
+		if m.Hash(key) != Hash(key) {
+			t.Fatalf("expected '%v', got '%v'", Hash(key), m.Hash(key))
+		}
+		shard := m.Shard(key)
+		if shard != int(Hash(key)%uint64(n)) {
+			t.Fatalf("expected '%v', got '%v'", Hash(key)%uint64(n), shard)
+		}
+		m.Set(key, i)
+	}
+	dist := m.Distribution()
+	for i := 0; i < 1000; i++ {
+		dist[m.Shard(fmt.Sprintf("%d", i))]--
+	}
+	for i, count := range dist {
+		if count != 0 {
+			t.Fatalf("shard %d: expected '%v', got '%v'", i, 0, count)
+		}
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	run := func() []Entry {
+		m := NewOptions(&Options{Shards: 3, Seed: 42, NewShardMap: NewSwiss})
+		if m.NumShards() != 4 {
+			t.Fatalf("expected '%v', got '%v'", 4, m.NumShards())
+		}
+		for i := 0; i < 100; i++ {
+			m.Set(k(i), i)
+		}
+		entries := m.Sample(10)
+		for i := 0; i < 10; i++ {
+			key, value, _ := m.PopAny()
+			entries = append(entries, Entry{key, value})
+		}
+		return entries
+	}
+	a, b := run(), run()
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("expected '%v', got '%v'", a, b)
+	}
+}
+
+func TestSchedHook(t *testing.T) {
+	m := NewOptions(&Options{Shards: 2})
+	var writes int64
+	m.sched = func(shard int, write bool) {
+		if write {
+			atomic.AddInt64(&writes, 1)
+		}
+		runtime.Gosched()
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.Set(k(i*100+j), j)
+				m.Get(k(j))
+			}
+		}(i)
+	}
+	wg.Wait()
+	if writes != 400 || m.Len() != 400 {
+		t.Fatalf("expected '%v', got '%v'", 400, writes)
+	}
+}
+
+func TestAcceptUnlocked(t *testing.T) {
+	var m Map
+	m.Set("hello", "world")
+	// the accept function may use the map
+	prev, replaced := m.SetAcceptUnlocked("hello", "planet",
+		func(prev interface{}, replaced bool) bool {
+			v, _ := m.Get("hello")
+			return v == prev
+		})
+	if !replaced || prev != "world" {
+		t.Fatalf("expected '%v', got '%v'", "world", prev)
+	}
+	// a change made during accept causes a retry
+	var calls int
+	prev, replaced = m.SetAcceptUnlocked("hello", "moon",
+		func(prev interface{}, replaced bool) bool {
+			calls++
+			if calls == 1 {
+				m.Set("hello", "sun")
+			}
+			return true
+		})
+	if calls != 2 || prev != "sun" {
+		t.Fatalf("expected '%v', got '%v'", "sun", prev)
+	}
+	if _, deleted := m.DeleteAcceptUnlocked("hello",
+		func(prev interface{}, deleted bool) bool {
+			return prev == "sun"
+		}); deleted {
+		t.Fatal("expected false")
+	}
+	prev, deleted := m.DeleteAcceptUnlocked("hello",
+		func(prev interface{}, deleted bool) bool {
+			m.Get("hello")
+			return prev == "moon"
+		})
+	if !deleted || prev != "moon" {
+		t.Fatalf("expected '%v', got '%v'", "moon", prev)
+	}
+}
+
+func TestLoadAndDelete(t *testing.T) {
+	var m Map
+	m.Set("hello", "world")
+	if v, loaded := m.LoadAndDelete("hello"); !loaded || v != "world" {
+		t.Fatalf("expected '%v', got '%v'", "world", v)
+	}
+	if _, loaded := m.LoadAndDelete("hello"); loaded {
+		t.Fatal("expected false")
+	}
+	m.Set("hello", "world")
+	v, loaded, hint := m.LoadAndDeleteHint("hello")
+	if !loaded || v != "world" || hint.shard != m.Shard("hello") {
+		t.Fatalf("expected '%v', got '%v'", "world", v)
+	}
+	if _, replaced := m.SetInShard(hint, "hello", "planet"); replaced {
+		t.Fatal("expected false")
+	}
+	if v, _ := m.Get("hello"); v != "planet" {
+		t.Fatalf("expected '%v', got '%v'", "planet", v)
+	}
+}
+
+func TestSetTransform(t *testing.T) {
+	var m Map
+	concat := func(value, prev interface{}, replaced bool) (interface{}, bool) {
+		if !replaced {
+			return value, true
+		}
+		return prev.(string) + value.(string), true
+	}
+	if _, replaced := m.SetTransform("a", "1", concat); replaced {
+		t.Fatal("expected false")
+	}
+	prev, replaced := m.SetTransform("a", "2", concat)
+	if !replaced || prev != "1" {
+		t.Fatalf("expected '%v', got '%v'", "1", prev)
+	}
+	if v, _ := m.Get("a"); v != "12" {
+		t.Fatalf("expected '%v', got '%v'", "12", v)
+	}
+	prev, replaced = m.SetTransform("a", "3",
+		func(value, prev interface{}, replaced bool) (interface{}, bool) {
+			return nil, false
+		})
+	if replaced || prev != nil {
+		t.Fatalf("expected '%v', got '%v'", nil, prev)
+	}
+	if v, _ := m.Get("a"); v != "12" {
+		t.Fatalf("expected '%v', got '%v'", "12", v)
+	}
+}
+
+func TestShardCap(t *testing.T) {
+	if n := shardCap(0, 16); n != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, n)
+	}
+	if n := shardCap(1600, 16); n != 130 {
+		t.Fatalf("expected '%v', got '%v'", 130, n)
+	}
+	const cap = 100000
+	m := NewOptions(&Options{Cap: cap, Shards: 64})
+	for i := 0; i < cap; i++ {
+		m.Set(k(i), i)
+	}
+	var over int
+	for _, n := range m.Distribution() {
+		if n > shardCap(cap, 64) {
+			over++
+		}
+	}
+	if over > 1 {
+		t.Fatalf("expected at most '%v', got '%v'", 1, over)
+	}
+	src := make(map[string]interface{})
+	for i := 0; i < 10000; i++ {
+		src[k(i)] = i
+	}
+	var sized int
+	m = NewOptions(&Options{Shards: 4, NewShardMap: func(cap int) ShardMap {
+		if cap > 2000 {
+			sized++
+		}
+		return NewSwiss(cap)
+	}})
+	m.LoadMap(src)
+	if sized != 4 {
+		t.Fatalf("expected '%v', got '%v'", 4, sized)
+	}
+	if m.Len() != 10000 {
+		t.Fatalf("expected '%v', got '%v'", 10000, m.Len())
+	}
+}
@@ -0,0 +1,127 @@
+package shardmap
+
+import "time"
+
+// Meta is the metadata of an entry, which is only recorded when
+// Options.TrackMeta is set.
+type Meta struct {
+	// Version changes every time that the entry is assigned, and is never
+	// reused for the same key, not even after the key has been deleted.
+	Version uint64
+	// Created is when the key was assigned while it did not exist.
+	Created time.Time
+	// Updated is when the entry was last assigned.
+	Updated time.Time
+	// Expires is when the entry's TTL passes, or zero when it has none.
+	Expires time.Time
+	// Stale is true when the entry's TTL has passed, but it's still being
+	// served. See Options.StaleWhileRevalidate.
+	Stale bool
+}
+
+// putMeta updates the metadata of an entry in a write locked shard, after
+// its value has been assigned. The version is taken from the shard's change
+// counter, which only ever increases.
+func (m *Map) putMeta(shard int, key string, replaced bool) {
+	now := m.clockNow()
+	var meta Meta
+	if replaced {
+		meta = m.metas[shard][key]
+	} else {
+		meta.Created = now
+	}
+	meta.Updated = now
+	meta.Version = m.vers[shard]
+	m.metas[shard][key] = meta
+}
+
+// GetMeta returns the metadata for a key.
+// Returns false when no value has been assign for key, or when the map does
+// not track metadata.
+func (m *Map) GetMeta(key string) (meta Meta, ok bool) {
+	m.initDo()
+	if m.metas == nil {
+		return meta, false
+	}
+	shard := m.choose(key)
+	t := m.rlock(shard)
+	if !m.expired(shard, key) {
+		meta, ok = m.metas[shard][key]
+		if e, has := m.expires[shard][key]; ok && has {
+			meta.Expires = clockEpoch.Add(time.Duration(e.at))
+			_, meta.Stale = m.isStale(shard, key)
+		}
+	}
+	m.runlockOp(shard, t, "GetMeta", key)
+	return meta, ok
+}
+
+// SetAcceptMeta is like SetAccept, but the "accept" function also receives
+// the metadata of the previous value, if any. It must only be used when the
+// map tracks metadata.
+func (m *Map) SetAcceptMeta(
+	key string, value interface{},
+	accept func(prev interface{}, meta Meta, replaced bool) bool,
+) (prev interface{}, replaced bool) {
+	m.mustTrack()
+	shard := m.choose(key)
+	t := m.lock(shard)
+	defer m.unlockOp(shard, t, "SetAcceptMeta", key)
+	m.expireKey(shard, key)
+	m.promote(shard, key)
+	prev, replaced = m.maps[shard].Get(key)
+	if !accept(prev, m.metas[shard][key], replaced) {
+		return nil, false
+	}
+	return m.set(shard, key, value)
+}
+
+// DeleteAcceptMeta is like DeleteAccept, but the "accept" function also
+// receives the metadata of the previous value, if any. It must only be used
+// when the map tracks metadata.
+func (m *Map) DeleteAcceptMeta(
+	key string,
+	accept func(prev interface{}, meta Meta, replaced bool) bool,
+) (prev interface{}, deleted bool) {
+	m.mustTrack()
+	shard := m.choose(key)
+	t := m.lock(shard)
+	defer m.unlockOp(shard, t, "DeleteAcceptMeta", key)
+	m.expireKey(shard, key)
+	m.promote(shard, key)
+	prev, deleted = m.maps[shard].Get(key)
+	if !accept(prev, m.metas[shard][key], deleted) {
+		return nil, false
+	}
+	return m.delete(shard, key)
+}
+
+func (m *Map) mustTrack() {
+	m.initDo()
+	if m.metas == nil {
+		panic("shardmap: map does not track metadata")
+	}
+}
+
+// SetVersion assigns a value to a key, but only when the current version of
+// the entry is "expected", which allows for optimistic concurrency without
+// having to compare values. An "expected" of zero means that the key must not
+// exist.
+// Returns the new version, or the current version and false when it did not
+// match, in which case the caller may retry with it. It must only be used
+// when the map tracks metadata.
+func (m *Map) SetVersion(key string, value interface{}, expected uint64) (
+	version uint64, ok bool,
+) {
+	m.mustTrack()
+	shard := m.choose(key)
+	t := m.lock(shard)
+	defer m.unlockOp(shard, t, "SetVersion", key)
+	m.expireKey(shard, key)
+	m.promote(shard, key)
+	if version = m.metas[shard][key].Version; version != expected {
+		return version, false
+	}
+	m.set(shard, key, value)
+	return m.metas[shard][key].Version, true
+}
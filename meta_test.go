@@ -0,0 +1,98 @@
+package shardmap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMeta(t *testing.T) {
+	m := NewOptions(&Options{TrackMeta: true})
+	m.Set("hello", "world")
+	meta, ok := m.GetMeta("hello")
+	if !ok || meta.Version == 0 || meta.Created.IsZero() ||
+		meta.Updated != meta.Created {
+		t.Fatalf("unexpected meta %+v", meta)
+	}
+	m.Set("hello", "planet")
+	meta2, _ := m.GetMeta("hello")
+	if meta2.Version <= meta.Version || meta2.Created != meta.Created ||
+		meta2.Updated.Before(meta.Updated) {
+		t.Fatalf("unexpected meta %+v", meta2)
+	}
+	// reject changes made since the first version was read
+	_, ok = m.SetAcceptMeta("hello", "moon",
+		func(prev interface{}, meta Meta, replaced bool) bool {
+			if !replaced || prev != "planet" || meta != meta2 {
+				t.Fatalf("unexpected meta %+v", meta)
+			}
+			return meta.Version == meta2.Version-1
+		})
+	if ok {
+		t.Fatal("expected false")
+	}
+	_, ok = m.DeleteAcceptMeta("hello",
+		func(prev interface{}, meta Meta, replaced bool) bool {
+			return meta.Version == meta2.Version
+		})
+	if !ok {
+		t.Fatal("expected true")
+	}
+	if _, ok := m.GetMeta("hello"); ok {
+		t.Fatal("expected false")
+	}
+	m.Set("hello", "again")
+	if meta3, _ := m.GetMeta("hello"); meta3.Version <= meta2.Version {
+		t.Fatalf("expected a new version, got %+v", meta3)
+	}
+	m.Clear()
+	if _, ok := m.GetMeta("hello"); ok {
+		t.Fatal("expected false")
+	}
+	var plain Map
+	plain.Set("hello", "world")
+	if _, ok := plain.GetMeta("hello"); ok {
+		t.Fatal("expected false")
+	}
+}
+
+func TestSetVersion(t *testing.T) {
+	m := NewOptions(&Options{TrackMeta: true})
+	v1, ok := m.SetVersion("hello", "world", 0)
+	if !ok || v1 == 0 {
+		t.Fatalf("expected a version, got '%v'", v1)
+	}
+	if v, ok := m.SetVersion("hello", "planet", 0); ok || v != v1 {
+		t.Fatalf("expected '%v', got '%v'", v1, v)
+	}
+	v2, ok := m.SetVersion("hello", "planet", v1)
+	if !ok || v2 <= v1 {
+		t.Fatalf("expected a newer version than '%v', got '%v'", v1, v2)
+	}
+	if v, ok := m.SetVersion("hello", "moon", v1); ok || v != v2 {
+		t.Fatalf("expected '%v', got '%v'", v2, v)
+	}
+	if v, _ := m.Get("hello"); v != "planet" {
+		t.Fatalf("expected '%v', got '%v'", "planet", v)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic")
+		}
+	}()
+	var plain Map
+	plain.SetVersion("hello", "world", 0)
+}
+
+func TestSetVersionExpired(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	m := NewOptions(&Options{TrackMeta: true, Clock: clock})
+	m.SetTTL("hello", "world", time.Minute)
+	clock.Add(time.Minute)
+	// an expired key no longer exists, thus it's expected to be new
+	if v, ok := m.SetVersion("hello", "planet", 0); !ok || v == 0 {
+		t.Fatalf("expected a version, got '%v'", v)
+	}
+	if v, _ := m.Get("hello"); v != "planet" {
+		t.Fatalf("expected '%v', got '%v'", "planet", v)
+	}
+}
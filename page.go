@@ -0,0 +1,101 @@
+package shardmap
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"sort"
+)
+
+// Page returns up to limit entries, starting after the position in token,
+// along with the token for the next page. Use an empty token for the first
+// page. The next token is empty once there are no more entries, or when the
+// token is invalid.
+//
+// Entries are returned in order of their shard, and then their key, and the
+// token holds the shard and the key of the last entry, thus it stays valid
+// while the map changes. Every key that's in the map for the whole iteration
+// is returned exactly once, and keys that are assigned or deleted during the
+// iteration are returned at most once. Expired entries are skipped.
+//
+// With Options.IndexKeys each page only visits the keys that it returns,
+// otherwise each page sorts the keys of the shards that it visits.
+func (m *Map) Page(token string, limit int) (entries []Entry, nextToken string) {
+	m.initDo()
+	shard, after, ok := decodePageToken(token)
+	if !ok || shard >= m.shards || limit < 1 {
+		return nil, ""
+	}
+	for ; shard < m.shards; shard++ {
+		t := m.rlock(shard)
+		entries = m.pageShard(entries, shard, after, limit-len(entries))
+		m.runlock(shard, t)
+		if len(entries) == limit {
+			last := entries[len(entries)-1].Key
+			return entries, encodePageToken(shard, &last)
+		}
+		after = nil
+	}
+	return entries, ""
+}
+
+// pageShard appends up to n entries of a read locked shard, in key order,
+// that come after the key "after", or from the start when it's nil.
+func (m *Map) pageShard(dst []Entry, shard int, after *string, n int) []Entry {
+	skip := func(key string) bool {
+		return after != nil && key <= *after
+	}
+	if m.index != nil {
+		var pivot string
+		if after != nil {
+			pivot = *after
+		}
+		m.ascendShard(shard, pivot, func(key string, value interface{}) bool {
+			if !skip(key) {
+				dst = append(dst, Entry{key, value})
+				n--
+			}
+			return n > 0
+		})
+		return dst
+	}
+	var entries []Entry
+	m.rangeShard(shard, func(key string, value interface{}) bool {
+		if !skip(key) {
+			entries = append(entries, Entry{key, value})
+		}
+		return true
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return append(dst, entries...)
+}
+
+// encodePageToken returns the token for a position in a Page iteration, which
+// is the shard as a uvarint, followed by the last key when there is one.
+func encodePageToken(shard int, last *string) string {
+	buf := appendUvarint(nil, uint64(shard))
+	if last != nil {
+		buf = append(buf, *last...)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodePageToken(token string) (shard int, after *string, ok bool) {
+	if token == "" {
+		return 0, nil, true
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, nil, false
+	}
+	x, n := binary.Uvarint(buf)
+	if n <= 0 || x > 1<<31 {
+		return 0, nil, false
+	}
+	key := string(buf[n:])
+	return int(x), &key, true
+}
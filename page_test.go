@@ -0,0 +1,49 @@
+package shardmap
+
+import "testing"
+
+func TestPage(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		m := NewOptions(&Options{IndexKeys: indexed, Shards: 8})
+		for i := 0; i < 1000; i++ {
+			m.Set(k(i), i)
+		}
+		seen := make(map[string]bool)
+		var token string
+		for pages := 0; ; pages++ {
+			if pages > 1000 {
+				t.Fatal("too many pages")
+			}
+			var entries []Entry
+			entries, token = m.Page(token, 7)
+			if len(entries) > 7 {
+				t.Fatalf("expected at most '%v', got '%v'", 7, len(entries))
+			}
+			for _, e := range entries {
+				if seen[e.Key] {
+					t.Fatalf("duplicate key '%v'", e.Key)
+				}
+				seen[e.Key] = true
+				if e.Value != add(e.Key, 0) {
+					t.Fatalf("expected '%v', got '%v'", add(e.Key, 0), e.Value)
+				}
+				// changes during the iteration
+				if x := add(e.Key, 0); x < 1000 {
+					m.Delete(k(x + 500))
+					m.Set(k(x+1000), x+1000)
+				}
+			}
+			if token == "" {
+				break
+			}
+		}
+		for i := 0; i < 500; i++ {
+			if !seen[k(i)] {
+				t.Fatalf("missing key '%v'", k(i))
+			}
+		}
+		if entries, next := m.Page("bad token", 10); entries != nil || next != "" {
+			t.Fatal("expected nothing")
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package shardmap
+
+// Pin exempts an entry from expiring and from being spilled, until Unpin is
+// called or the key is deleted. Assigning a new value keeps the pin. Pinned
+// entries don't count towards Options.SpillLen.
+// Returns false when no value has been assign for key.
+func (m *Map) Pin(key string) bool {
+	m.initDo()
+	shard := m.choose(key)
+	t := m.lock(shard)
+	defer m.unlockOp(shard, t, "Pin", key)
+	m.promote(shard, key)
+	if _, ok := m.lookup(shard, key); !ok {
+		return false
+	}
+	if m.pins[shard] == nil {
+		m.pins[shard] = make(map[string]bool)
+	}
+	m.pins[shard][key] = true
+	if m.spill != nil {
+		m.removed(shard, key)
+	}
+	return true
+}
+
+// Unpin removes the pin from an entry, after which it may expire or be
+// spilled again.
+// Returns false when the key was not pinned.
+func (m *Map) Unpin(key string) bool {
+	m.initDo()
+	shard := m.choose(key)
+	t := m.lock(shard)
+	defer m.unlockOp(shard, t, "Unpin", key)
+	if !m.pins[shard][key] {
+		return false
+	}
+	delete(m.pins[shard], key)
+	if m.spill != nil {
+		m.added(shard, key)
+	}
+	return true
+}
+
+// Pinned returns true when the entry for a key is pinned.
+func (m *Map) Pinned(key string) bool {
+	m.initDo()
+	shard := m.choose(key)
+	t := m.rlock(shard)
+	pinned := m.pins[shard][key]
+	m.runlockOp(shard, t, "Pinned", key)
+	return pinned
+}
+
+// pinnedLen returns the number of pinned entries.
+func (m *Map) pinnedLen() int {
+	m.initDo()
+	var n int
+	for i := 0; i < m.shards; i++ {
+		t := m.rlock(i)
+		n += len(m.pins[i])
+		m.runlock(i, t)
+	}
+	return n
+}
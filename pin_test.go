@@ -0,0 +1,59 @@
+package shardmap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPin(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	m := NewOptions(&Options{Clock: clock})
+	if m.Pin("a") {
+		t.Fatal("expected false")
+	}
+	m.SetTTL("a", 1, time.Second)
+	m.SetTTL("b", 2, time.Second)
+	if !m.Pin("a") || !m.Pinned("a") || m.Pinned("b") {
+		t.Fatal("expected true")
+	}
+	if n := m.Stats().Pinned; n != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, n)
+	}
+	clock.Add(time.Second * 2)
+	if n := m.DeleteExpired(); n != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, n)
+	}
+	if v, ok := m.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, v)
+	}
+	if !m.Unpin("a") || m.Unpin("a") {
+		t.Fatal("expected true then false")
+	}
+	if v, ok := m.Get("a"); ok {
+		t.Fatalf("expected '%v', got '%v'", nil, v)
+	}
+	m.Set("c", 3)
+	m.Pin("c")
+	m.Delete("c")
+	if n := m.Stats().Pinned; n != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, n)
+	}
+}
+
+func TestPinSpill(t *testing.T) {
+	store := &testStore{data: make(map[string][]byte)}
+	m := NewOptions(&Options{Shards: 1, Spill: store, SpillLen: 10})
+	for i := 0; i < 100; i++ {
+		m.Set(k(i), k(i))
+		if i == 0 {
+			m.Pin(k(i))
+		}
+	}
+	if !m.Pinned(k(0)) || store.len() != 89 {
+		t.Fatalf("expected '%v', got '%v'", 89, store.len())
+	}
+	m.Unpin(k(0))
+	if store.len() != 90 {
+		t.Fatalf("expected '%v', got '%v'", 90, store.len())
+	}
+}
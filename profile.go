@@ -0,0 +1,90 @@
+package shardmap
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ShardContention reports how the lock for a single shard has been used.
+type ShardContention struct {
+	Shard int
+	// Reads and Writes are the number of times the shard was read locked and
+	// write locked.
+	Reads, Writes int64
+	// ReadWait and WriteWait are the total time spent waiting to acquire the
+	// lock.
+	ReadWait, WriteWait time.Duration
+	// ReadHeld and WriteHeld are the total time the lock was held, which is
+	// the latency of the operations, not counting the wait.
+	ReadHeld, WriteHeld time.Duration
+	// MaxWait is the longest wait for a single acquisition.
+	MaxWait time.Duration
+}
+
+type lockProfile struct {
+	start  time.Time
+	shards []shardProfile
+}
+
+type shardProfile struct {
+	reads, writes       int64
+	readWait, writeWait int64
+	readHeld, writeHeld int64
+	maxWait             int64
+	_                   [8]byte // pad to a cache line
+}
+
+func (p *lockProfile) now() int64 {
+	return int64(time.Since(p.start))
+}
+
+func (p *lockProfile) acquired(shard int, write bool, wait int64) {
+	s := &p.shards[shard]
+	if write {
+		atomic.AddInt64(&s.writes, 1)
+		atomic.AddInt64(&s.writeWait, wait)
+	} else {
+		atomic.AddInt64(&s.reads, 1)
+		atomic.AddInt64(&s.readWait, wait)
+	}
+	for {
+		max := atomic.LoadInt64(&s.maxWait)
+		if wait <= max || atomic.CompareAndSwapInt64(&s.maxWait, max, wait) {
+			break
+		}
+	}
+}
+
+func (p *lockProfile) released(shard int, write bool, held int64) {
+	s := &p.shards[shard]
+	if write {
+		atomic.AddInt64(&s.writeHeld, held)
+	} else {
+		atomic.AddInt64(&s.readHeld, held)
+	}
+}
+
+// ContentionReport returns the lock usage of each shard, which can be used to
+// find out whether a single hot shard is slowing things down. Returns nil
+// unless the map was created with the ProfileLocks option.
+func (m *Map) ContentionReport() []ShardContention {
+	m.initDo()
+	if m.prof == nil {
+		return nil
+	}
+	report := make([]ShardContention, len(m.prof.shards))
+	for i := range m.prof.shards {
+		s := &m.prof.shards[i]
+		report[i] = ShardContention{
+			Shard:     i,
+			Reads:     atomic.LoadInt64(&s.reads),
+			Writes:    atomic.LoadInt64(&s.writes),
+			ReadWait:  time.Duration(atomic.LoadInt64(&s.readWait)),
+			WriteWait: time.Duration(atomic.LoadInt64(&s.writeWait)),
+			ReadHeld:  time.Duration(atomic.LoadInt64(&s.readHeld)),
+			WriteHeld: time.Duration(atomic.LoadInt64(&s.writeHeld)),
+			MaxWait:   time.Duration(atomic.LoadInt64(&s.maxWait)),
+		}
+	}
+	return report
+}
@@ -0,0 +1,47 @@
+package shardmap
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestContentionReport(t *testing.T) {
+	var m Map
+	if m.ContentionReport() != nil {
+		t.Fatal("expected nil")
+	}
+	p := NewOptions(&Options{ProfileLocks: true})
+	p.Set("hello", "world")
+	p.Get("hello")
+	p.Get("hello")
+	shard := p.choose("hello")
+
+	// hold the shard while another goroutine waits on it
+	var wg sync.WaitGroup
+	p.SetAccept("hello", "planet", func(interface{}, bool) bool {
+		wg.Add(1)
+		go func() {
+			p.Get("hello")
+			wg.Done()
+		}()
+		time.Sleep(time.Millisecond * 20)
+		return true
+	})
+	wg.Wait()
+
+	report := p.ContentionReport()
+	if len(report) != p.shards {
+		t.Fatalf("expected '%v', got '%v'", p.shards, len(report))
+	}
+	r := report[shard]
+	if r.Shard != shard || r.Reads != 3 || r.Writes != 2 {
+		t.Fatalf("unexpected report %+v", r)
+	}
+	if r.WriteHeld < time.Millisecond*20 || r.ReadWait < time.Millisecond {
+		t.Fatalf("unexpected report %+v", r)
+	}
+	if r.MaxWait < time.Millisecond {
+		t.Fatalf("unexpected report %+v", r)
+	}
+}
@@ -0,0 +1,85 @@
+package shardmap
+
+import "sync"
+
+// writeOp is a Set or Delete that is queued for a shard writer.
+type writeOp struct {
+	key    string
+	value  interface{}
+	delete bool
+	prev   interface{}
+	ok     bool
+	done   chan struct{}
+}
+
+var writeOpPool = sync.Pool{
+	New: func() interface{} {
+		return &writeOp{done: make(chan struct{}, 1)}
+	},
+}
+
+// enqueue hands a Set or Delete to the writer of a shard, and waits for the
+// result. Once the map is closed the operation is applied right away instead.
+func (m *Map) enqueue(shard int, key string, value interface{}, delete bool) (
+	prev interface{}, ok bool,
+) {
+	m.qmus[shard].RLock()
+	if m.isClosed() {
+		m.qmus[shard].RUnlock()
+		t := m.lock(shard)
+		if delete {
+			prev, ok = m.delete(shard, key)
+		} else {
+			prev, ok = m.set(shard, key, value)
+		}
+		m.unlock(shard, t)
+		return prev, ok
+	}
+	op := writeOpPool.Get().(*writeOp)
+	op.key, op.value, op.delete = key, value, delete
+	m.queues[shard] <- op
+	m.qmus[shard].RUnlock()
+	<-op.done
+	prev, ok = op.prev, op.ok
+	*op = writeOp{done: op.done}
+	writeOpPool.Put(op)
+	return prev, ok
+}
+
+// writer applies the queued operations for a shard. Everything that is
+// waiting in the queue is applied together, under a single lock acquisition,
+// so the busier the shard the larger the batches. The writer holds the shard
+// lock, as other writes may bypass the queue, but Get doesn't take it, see
+// seqLookup.
+func (m *Map) writer(shard int, queue chan *writeOp) {
+	defer m.writers.Done()
+	ops := make([]*writeOp, 0, cap(queue))
+	for op := range queue {
+		ops = append(ops[:0], op)
+	drain:
+		for len(ops) < cap(ops) {
+			select {
+			case op, ok := <-queue:
+				if !ok {
+					// closed, the range loop ends after this batch
+					break drain
+				}
+				ops = append(ops, op)
+			default:
+				break drain
+			}
+		}
+		t := m.lock(shard)
+		for _, op := range ops {
+			if op.delete {
+				op.prev, op.ok = m.delete(shard, op.key)
+			} else {
+				op.prev, op.ok = m.set(shard, op.key, op.value)
+			}
+		}
+		m.unlock(shard, t)
+		for _, op := range ops {
+			op.done <- struct{}{}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package shardmap
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestWriteQueue(t *testing.T) {
+	m := NewOptions(&Options{WriteQueue: 64})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := i * 1000; j < (i+1)*1000; j++ {
+				if _, replaced := m.Set(k(j), j); replaced {
+					t.Errorf("expected false")
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if m.Len() != 8000 {
+		t.Fatalf("expected '%v', got '%v'", 8000, m.Len())
+	}
+	for i := 0; i < 8000; i++ {
+		if v, _ := m.Get(k(i)); v != i {
+			t.Fatalf("expected '%v', got '%v'", i, v)
+		}
+	}
+	if prev, ok := m.Set(k(0), "x"); !ok || prev != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, prev)
+	}
+	if prev, ok := m.Delete(k(0)); !ok || prev != "x" {
+		t.Fatalf("expected '%v', got '%v'", "x", prev)
+	}
+	if _, ok := m.Delete(k(0)); ok {
+		t.Fatal("expected false")
+	}
+}
+
+func TestWriteQueueReadView(t *testing.T) {
+	m := NewOptions(&Options{WriteQueue: 64})
+	for i := 0; i < 100; i++ {
+		m.Set(k(i), i)
+	}
+	v := m.ReadView()
+	m.Set(k(100), 100)
+	if _, ok := v.Get(k(100)); ok {
+		t.Fatal("expected the view to be unchanged")
+	}
+	v = m.RefreshView()
+	if v.Len() != 101 {
+		t.Fatalf("expected '%v', got '%v'", 101, v.Len())
+	}
+	if val, _ := v.Get(k(100)); val != 100 {
+		t.Fatalf("expected '%v', got '%v'", 100, val)
+	}
+}
+
+func TestWriteQueueLockFreeGet(t *testing.T) {
+	m := NewOptions(&Options{WriteQueue: 64})
+	m.Set("a", 1)
+	if m.seqs == nil {
+		t.Fatal("expected lock free reads")
+	}
+	// Get doesn't wait for a write locked shard
+	shard := m.choose("a")
+	at := m.lock(shard)
+	got := make(chan interface{})
+	go func() {
+		v, _ := m.Get("a")
+		got <- v
+	}()
+	if v := <-got; v != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, v)
+	}
+	m.unlock(shard, at)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				m.Set(k(i*1000+j), j)
+				if v, _ := m.Get(k(i*1000 + j)); v != j {
+					t.Errorf("expected '%v', got '%v'", j, v)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
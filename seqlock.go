@@ -0,0 +1,262 @@
+package shardmap
+
+import (
+	"sync/atomic"
+
+	"github.com/cespare/xxhash"
+)
+
+// seqLock lets Get read a shard without taking its read lock. The sequence
+// is odd while the shard is write locked, and readers retry when it changed
+// during their read, so like with the read lock they only see the shard as
+// it is between writes. Readers don't write to shared memory at all, which
+// an RWMutex does on every RLock and RUnlock.
+type seqLock struct {
+	seq   uint64
+	shard atomic.Value // *seqShard
+}
+
+// seqRetries is how many times a read is retried before falling back to the
+// read lock.
+const seqRetries = 4
+
+// seqLookup returns a value for a key without locking the shard. Returns
+// false for "valid" when the read didn't succeed, and the caller must fall
+// back to the read lock.
+//
+// With a WriteQueue the sequence isn't checked, so that Get doesn't wait for
+// the batches. Reading a single key of a seqShard doesn't need it, the entry
+// that's found was in the table at some point during the read.
+func (m *Map) seqLookup(shard int, key string) (
+	value interface{}, ok, valid bool,
+) {
+	l := &m.seqs[shard]
+	if m.queues != nil {
+		if atomic.LoadInt32(&m.hasTTL) != 0 {
+			return nil, false, false
+		}
+		value, ok = l.shard.Load().(*seqShard).load(key)
+		return value, ok, true
+	}
+	for i := 0; i < seqRetries; i++ {
+		seq := atomic.LoadUint64(&l.seq)
+		if seq&1 == 1 {
+			continue
+		}
+		if atomic.LoadInt32(&m.hasTTL) != 0 {
+			// expirations are kept in plain maps
+			return nil, false, false
+		}
+		value, ok = l.shard.Load().(*seqShard).load(key)
+		if atomic.LoadUint64(&l.seq) == seq {
+			return value, ok, true
+		}
+	}
+	return nil, false, false
+}
+
+// seqWrite moves the sequence of a write locked shard to odd, and back to
+// even, around every write lock.
+func (m *Map) seqWrite(shard int) {
+	if m.seqs != nil {
+		atomic.AddUint64(&m.seqs[shard].seq, 1)
+	}
+}
+
+// setShardMap replaces the shard map of a write locked shard.
+func (m *Map) setShardMap(shard int, sm ShardMap) {
+	m.maps[shard] = sm
+	if m.seqs != nil {
+		m.seqs[shard].shard.Store(sm.(*seqShard))
+	}
+}
+
+// seqEntry is an entry of a seqShard. Entries are never changed once they are
+// in the table, they are replaced, thus readers can't see a torn entry.
+type seqEntry struct {
+	key   string
+	value interface{}
+}
+
+// seqTombstone marks a deleted slot.
+var seqTombstone = new(seqEntry)
+
+type seqTable struct {
+	slots []atomic.Value // *seqEntry
+	mask  uint64
+}
+
+// seqShard is an open addressing hashmap, with linear probing, that can be
+// read while it's being written to. Every slot is an atomic pointer to an
+// entry, and growing publishes a new table only once it's complete.
+type seqShard struct {
+	tab   atomic.Value // *seqTable
+	count int          // entries
+	used  int          // entries and tombstones
+}
+
+func newSeqShard(cap int) ShardMap {
+	s := new(seqShard)
+	s.tab.Store(newSeqTable(cap))
+	return s
+}
+
+// newSeqTable returns a table for cap entries, at a max load of 3/4.
+func newSeqTable(cap int) *seqTable {
+	n := 8
+	for n*3/4 < cap {
+		n *= 2
+	}
+	return &seqTable{slots: make([]atomic.Value, n), mask: uint64(n - 1)}
+}
+
+func (t *seqTable) entry(i uint64) *seqEntry {
+	e, _ := t.slots[i].Load().(*seqEntry)
+	return e
+}
+
+// start returns the slot where the probe for a key starts. The map picks
+// shards using the low bits of the key hash, thus all keys in a shard share
+// those bits, and the hash is mixed by mix64 so that they don't all start
+// in the same few slots.
+func (t *seqTable) start(key string) uint64 {
+	return mix64(xxhash.Sum64String(key)) & t.mask
+}
+
+// load returns a value for a key. It's safe to call without any locks.
+func (s *seqShard) load(key string) (value interface{}, ok bool) {
+	t := s.tab.Load().(*seqTable)
+	for i := t.start(key); ; i = (i + 1) & t.mask {
+		e := t.entry(i)
+		if e == nil {
+			return nil, false
+		}
+		if e != seqTombstone && e.key == key {
+			return e.value, true
+		}
+	}
+}
+
+// Set assigns a value to a key.
+func (s *seqShard) Set(key string, value interface{}) (
+	prev interface{}, replaced bool,
+) {
+	t := s.tab.Load().(*seqTable)
+	if (s.used+1)*4 > len(t.slots)*3 {
+		t = s.grow()
+	}
+	free := int64(-1)
+	i := t.start(key)
+	for ; ; i = (i + 1) & t.mask {
+		e := t.entry(i)
+		if e == nil {
+			break
+		}
+		if e == seqTombstone {
+			if free < 0 {
+				free = int64(i)
+			}
+			continue
+		}
+		if e.key == key {
+			// keep the existing key, like the other shard maps
+			t.slots[i].Store(&seqEntry{e.key, value})
+			return e.value, true
+		}
+	}
+	if free >= 0 {
+		i = uint64(free)
+	} else {
+		s.used++
+	}
+	t.slots[i].Store(&seqEntry{key, value})
+	s.count++
+	return nil, false
+}
+
+// Get returns a value for a key.
+func (s *seqShard) Get(key string) (value interface{}, ok bool) {
+	return s.load(key)
+}
+
+// Delete deletes a value for a key.
+func (s *seqShard) Delete(key string) (prev interface{}, deleted bool) {
+	t := s.tab.Load().(*seqTable)
+	for i := t.start(key); ; i = (i + 1) & t.mask {
+		e := t.entry(i)
+		if e == nil {
+			return nil, false
+		}
+		if e != seqTombstone && e.key == key {
+			t.slots[i].Store(seqTombstone)
+			s.count--
+			return e.value, true
+		}
+	}
+}
+
+// Len returns the number of values in map.
+func (s *seqShard) Len() int {
+	return s.count
+}
+
+// Range iterates over all key/values.
+func (s *seqShard) Range(iter func(key string, value interface{}) bool) {
+	t := s.tab.Load().(*seqTable)
+	for i := range t.slots {
+		if e := t.entry(uint64(i)); e != nil && e != seqTombstone {
+			if !iter(e.key, e.value) {
+				return
+			}
+		}
+	}
+}
+
+// grow publishes a new table with room for twice as many entries, which also
+// drops the tombstones.
+func (s *seqShard) grow() *seqTable {
+	old := s.tab.Load().(*seqTable)
+	t := newSeqTable((s.count + 1) * 2)
+	for i := range old.slots {
+		e := old.entry(uint64(i))
+		if e == nil || e == seqTombstone {
+			continue
+		}
+		j := t.start(e.key)
+		for t.entry(j) != nil {
+			j = (j + 1) & t.mask
+		}
+		t.slots[j].Store(e)
+	}
+	s.used = s.count
+	s.tab.Store(t)
+	return t
+}
+
+// ProbeStats looks up every key, and reports how far each one was from where
+// its hash places it.
+func (s *seqShard) ProbeStats() ProbeStats {
+	var ps ProbeStats
+	t := s.tab.Load().(*seqTable)
+	s.Range(func(key string, value interface{}) bool {
+		ps.Keys++
+		for i, n := t.start(key), 1; ; i, n = (i+1)&t.mask, n+1 {
+			ps.Probes++
+			if n > ps.MaxProbes {
+				ps.MaxProbes = n
+			}
+			e := t.entry(i)
+			if e == seqTombstone {
+				continue
+			}
+			if e.key == key {
+				return true
+			}
+			ps.FalseMatches++
+			if xxhash.Sum64String(e.key) == xxhash.Sum64String(key) {
+				ps.Collisions++
+			}
+		}
+	})
+	return ps
+}
@@ -0,0 +1,112 @@
+package shardmap
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSeqShard(t *testing.T) {
+	s := newSeqShard(0)
+	exp := make(map[string]interface{})
+	for i := 0; i < 100000; i++ {
+		key := k(rand.Intn(5000))
+		switch rand.Intn(3) {
+		case 0, 1:
+			prev, replaced := s.Set(key, i)
+			eprev, ereplaced := exp[key]
+			if replaced != ereplaced || prev != eprev {
+				t.Fatalf("expected %v/%v, got %v/%v",
+					eprev, ereplaced, prev, replaced)
+			}
+			exp[key] = i
+		case 2:
+			prev, deleted := s.Delete(key)
+			eprev, edeleted := exp[key]
+			if deleted != edeleted || prev != eprev {
+				t.Fatalf("expected %v/%v, got %v/%v",
+					eprev, edeleted, prev, deleted)
+			}
+			delete(exp, key)
+		}
+	}
+	if s.Len() != len(exp) {
+		t.Fatalf("expected %v, got %v", len(exp), s.Len())
+	}
+	var n int
+	s.Range(func(key string, value interface{}) bool {
+		if exp[key] != value {
+			t.Fatalf("expected %v, got %v", exp[key], value)
+		}
+		n++
+		return true
+	})
+	if n != len(exp) {
+		t.Fatalf("expected %v, got %v", len(exp), n)
+	}
+}
+
+// TestSeqLockReads has readers check that a key is never missing while
+// batches delete and set it again under a single lock, which a reader that
+// doesn't respect the write lock would see.
+func TestSeqLockReads(t *testing.T) {
+	m := NewOptions(&Options{SeqLockReads: true, Shards: 4})
+	for i := 0; i < 100; i++ {
+		m.Set(k(i), i)
+	}
+	var stop int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; atomic.LoadInt32(&stop) == 0; j++ {
+				if v, ok := m.Get(k(j % 100)); !ok || v.(int)%100 != j%100 {
+					t.Errorf("expected '%v', got '%v'", j%100, v)
+					return
+				}
+			}
+		}()
+	}
+	b := m.NewBatch()
+	for i := 0; i < 1000; i++ {
+		key := k(i % 100)
+		b.Delete(key)
+		b.Set(key, i)
+		if i%10 == 9 {
+			b.Apply()
+		}
+		if i%100 == 0 {
+			// grows the tables
+			m.Set(k(i+1000), i)
+		}
+	}
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+	m.SetTTL("ttl", 1, time.Hour)
+	if v, _ := m.Get("ttl"); v != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, v)
+	}
+	m.Clear()
+	if _, ok := m.Get(k(1)); ok {
+		t.Fatal("expected false")
+	}
+}
+
+func TestSeqLockManyShards(t *testing.T) {
+	// all keys in a shard share the low bits of their hash, which must not
+	// make them cluster in the shard's table
+	m := NewOptions(&Options{SeqLockReads: true, Shards: 256})
+	for i := 0; i < 100000; i++ {
+		m.Set(k(i), i)
+	}
+	ps, ok := m.ProbeStats()
+	if !ok || ps.Keys != 100000 {
+		t.Fatalf("unexpected probe stats %+v", ps)
+	}
+	if ps.Probes > ps.Keys*2 {
+		t.Fatalf("unexpected probe stats %+v", ps)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !shardmap_small && !js && !wasip1 && !tinygo
+// +build !shardmap_small,!js,!wasip1,!tinygo
+
+package shardmap
+
+import "runtime"
+
+// numShards returns the number of shards that a new map should use, which is
+// the power of two that is closest to, but not smaller than, NumCPU*16.
+func numShards() int {
+	n := 1
+	for n < runtime.NumCPU()*16 {
+		n *= 2
+	}
+	return n
+}
+
+// numWorkers returns the number of goroutines that functions working on all
+// shards at once, such as FromMap and Stream, spread the shards over.
+func numWorkers() int {
+	return runtime.GOMAXPROCS(0)
+}
@@ -0,0 +1,23 @@
+//go:build shardmap_small || js || wasip1 || tinygo
+// +build shardmap_small js wasip1 tinygo
+
+package shardmap
+
+// The small build is for targets with a single thread or little memory, such
+// as GOOS=js, wasip1 and TinyGo, where it's selected automatically, or any
+// other target when built with the shardmap_small tag. Maps default to a few
+// shards, rather than sixteen per CPU, and functions working on all shards
+// at once visit them in turn on the calling goroutine. Options.Shards still
+// overrides the number of shards. Goroutines that are started by options,
+// such as SweepInterval and WriteQueue, are left as they are.
+
+// smallShards is the default number of shards in the small build.
+const smallShards = 4
+
+func numShards() int {
+	return smallShards
+}
+
+func numWorkers() int {
+	return 1
+}
@@ -0,0 +1,24 @@
+package shardmap
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestParallel(t *testing.T) {
+	n := numShards()
+	if n < 1 || n&(n-1) != 0 {
+		t.Fatalf("expected a power of two, got '%v'", n)
+	}
+	var m Map
+	m.initDo()
+	visits := make([]int32, m.shards)
+	m.parallel(func(shard int) {
+		atomic.AddInt32(&visits[shard], 1)
+	})
+	for shard, n := range visits {
+		if n != 1 {
+			t.Fatalf("shard %v: expected '%v', got '%v'", shard, 1, n)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package shardmap
+
+// AppendSlice appends items to the []interface{} value of a key, or assigns
+// a new slice when the key has no value. The slice is copied rather than
+// appended to in place, thus slices that were returned by Get before are
+// never modified, and may be read while the key is being changed.
+// Returns the new length, or false when the value is not a []interface{}.
+func (m *Map) AppendSlice(key string, items ...interface{}) (n int, ok bool) {
+	_, ok = m.updateSlice("AppendSlice", key,
+		func(s []interface{}) []interface{} {
+			ns := make([]interface{}, len(s)+len(items))
+			copy(ns, s)
+			copy(ns[len(s):], items)
+			n = len(ns)
+			return ns
+		})
+	return n, ok
+}
+
+// TrimSlice keeps only the last max items of the []interface{} value of a
+// key, such as to cap a buffer of recent events, and deletes the key when no
+// items are left. Like AppendSlice, slices returned by Get before are not
+// modified. The slice is not copied, thus the removed items are only released
+// by the next AppendSlice.
+// Returns the number of items removed, or false when no value has been assign
+// for key, or when the value is not a []interface{}.
+func (m *Map) TrimSlice(key string, max int) (n int, ok bool) {
+	if max < 0 {
+		max = 0
+	}
+	found, ok := m.updateSlice("TrimSlice", key,
+		func(s []interface{}) []interface{} {
+			if len(s) <= max {
+				return s
+			}
+			n = len(s) - max
+			return s[n:len(s):len(s)]
+		})
+	return n, found && ok
+}
+
+// PopSlice removes the last item of the []interface{} value of a key, and
+// deletes the key when no items are left. Like AppendSlice, slices returned
+// by Get before are not modified.
+// Returns the removed item, or false when the key has no items, or when the
+// value is not a []interface{}.
+func (m *Map) PopSlice(key string) (item interface{}, ok bool) {
+	m.updateSlice("PopSlice", key,
+		func(s []interface{}) []interface{} {
+			if len(s) == 0 {
+				return s
+			}
+			item, ok = s[len(s)-1], true
+			return s[: len(s)-1 : len(s)-1]
+		})
+	return item, ok
+}
+
+// updateSlice replaces the []interface{} value of a key with the slice that
+// "update" returns for it, under the shard lock, and deletes the key when the
+// slice is empty. The "update" function is not called for other values. The
+// op names the caller for Options.SlowOpThreshold. Like RangeUpdate, the key
+// keeps its expiration and cost.
+// Returns false for "found" when no value has been assign for key, and false
+// for "ok" when the value is not a []interface{}.
+func (m *Map) updateSlice(
+	op, key string, update func(s []interface{}) []interface{},
+) (found, ok bool) {
+	m.initDo()
+	shard := m.choose(key)
+	t := m.lock(shard)
+	defer m.unlockOp(shard, t, op, key)
+	m.expireKey(shard, key)
+	m.promote(shard, key)
+	prev, found := m.maps[shard].Get(key)
+	s, ok := prev.([]interface{})
+	if found && !ok {
+		return true, false
+	}
+	s = update(s)
+	if len(s) > 0 {
+		m.replace(shard, key, s)
+	} else if found {
+		m.delete(shard, key)
+	}
+	return found, true
+}
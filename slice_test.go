@@ -0,0 +1,123 @@
+package shardmap
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSlices(t *testing.T) {
+	var m Map
+	if n, ok := m.AppendSlice("a", 1, 2); !ok || n != 2 {
+		t.Fatalf("expected '%v', got '%v'", 2, n)
+	}
+	if n, ok := m.AppendSlice("a", 3); !ok || n != 3 {
+		t.Fatalf("expected '%v', got '%v'", 3, n)
+	}
+	v, _ := m.Get("a")
+	old := v.([]interface{})
+	if n, ok := m.TrimSlice("a", 2); !ok || n != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, n)
+	}
+	if item, ok := m.PopSlice("a"); !ok || item != 3 {
+		t.Fatalf("expected '%v', got '%v'", 3, item)
+	}
+	m.AppendSlice("a", 4)
+	if len(old) != 3 || old[0] != 1 || old[1] != 2 || old[2] != 3 {
+		t.Fatalf("expected '%v', got '%v'", []interface{}{1, 2, 3}, old)
+	}
+	v, _ = m.Get("a")
+	if s := v.([]interface{}); len(s) != 2 || s[0] != 2 || s[1] != 4 {
+		t.Fatalf("expected '%v', got '%v'", []interface{}{2, 4}, s)
+	}
+	m.PopSlice("a")
+	m.PopSlice("a")
+	if _, ok := m.Get("a"); ok {
+		t.Fatal("expected false")
+	}
+	if _, ok := m.PopSlice("a"); ok {
+		t.Fatal("expected false")
+	}
+	m.Set("b", "str")
+	if _, ok := m.AppendSlice("b", 1); ok {
+		t.Fatal("expected false")
+	}
+	if _, ok := m.TrimSlice("b", 0); ok {
+		t.Fatal("expected false")
+	}
+	if _, ok := m.PopSlice("b"); ok {
+		t.Fatal("expected false")
+	}
+	m.AppendSlice("c", 1, 2, 3)
+	if n, ok := m.TrimSlice("c", 0); !ok || n != 3 {
+		t.Fatalf("expected '%v', got '%v'", 3, n)
+	}
+	if m.Len() != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, m.Len())
+	}
+}
+
+func TestSlicesConcurrent(t *testing.T) {
+	var m Map
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				m.AppendSlice("a", j)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if v, ok := m.Get("a"); ok {
+					for _, item := range v.([]interface{}) {
+						_ = item.(int)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	v, _ := m.Get("a")
+	if n := len(v.([]interface{})); n != 8000 {
+		t.Fatalf("expected '%v', got '%v'", 8000, n)
+	}
+}
+
+func TestSliceKeepsTTLAndCost(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	m := NewOptions(&Options{Clock: clock})
+	if _, ok := m.TrimSlice("missing", 1); ok {
+		t.Fatal("expected false")
+	}
+	if _, ok := m.Get("missing"); ok {
+		t.Fatal("expected false")
+	}
+	m.SetTTL("a", []interface{}{1}, time.Minute)
+	m.AppendSlice("a", 2, 3)
+	if n, ok := m.TrimSlice("a", 2); !ok || n != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, n)
+	}
+	if ttl, _ := m.TTL("a"); ttl != time.Minute {
+		t.Fatalf("expected '%v', got '%v'", time.Minute, ttl)
+	}
+	m.SetWithCost("b", []interface{}{1}, 10)
+	m.AppendSlice("b", 2)
+	m.PopSlice("b")
+	if m.Cost() != 10 {
+		t.Fatalf("expected '%v', got '%v'", 10, m.Cost())
+	}
+	m.PopSlice("b")
+	if m.Cost() != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, m.Cost())
+	}
+	clock.Add(time.Minute)
+	if _, ok := m.AppendSlice("a", 4); !ok {
+		t.Fatal("expected true")
+	}
+	if ttl, _ := m.TTL("a"); ttl >= 0 {
+		t.Fatalf("expected no expiration, got '%v'", ttl)
+	}
+}
@@ -0,0 +1,52 @@
+package shardmap
+
+import "time"
+
+// OpInfo describes an operation that held a shard lock for longer than
+// Options.SlowOpThreshold.
+type OpInfo struct {
+	// Op is the name of the function, such as "SetAccept" or "Range". It's
+	// empty for some of the other functions that lock shards, such as Len.
+	Op string
+	// Key is the key of the operation, or empty when it's not for a single
+	// key, such as Range.
+	Key   string
+	Shard int
+	// Write is true when the shard was write locked.
+	Write bool
+	// Held is how long the lock was held.
+	Held time.Duration
+}
+
+type slowOps struct {
+	start     time.Time
+	threshold int64
+	fn        func(op OpInfo)
+}
+
+// timed reports whether the shard locks are timed, for ProfileLocks or for
+// OnSlowOp.
+func (m *Map) timed() bool {
+	return m.prof != nil || m.slow != nil
+}
+
+// lockTime returns the time used for timing the shard locks.
+func (m *Map) lockTime() int64 {
+	if m.prof != nil {
+		return m.prof.now()
+	}
+	return int64(time.Since(m.slow.start))
+}
+
+// slowOp reports an operation to OnSlowOp, if it held a lock for too long.
+func (m *Map) slowOp(shard int, write bool, held int64, op, key string) {
+	if m.slow != nil && held > m.slow.threshold {
+		m.slow.fn(OpInfo{
+			Op:    op,
+			Key:   key,
+			Shard: shard,
+			Write: write,
+			Held:  time.Duration(held),
+		})
+	}
+}
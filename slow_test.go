@@ -0,0 +1,131 @@
+package shardmap
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSlowOps(t *testing.T) {
+	var mu sync.Mutex
+	var ops []OpInfo
+	m := NewOptions(&Options{
+		SlowOpThreshold: 10 * time.Millisecond,
+		OnSlowOp: func(op OpInfo) {
+			mu.Lock()
+			ops = append(ops, op)
+			mu.Unlock()
+		},
+	})
+	for i := 0; i < 100; i++ {
+		m.Set(k(i), i)
+	}
+	if len(ops) != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, len(ops))
+	}
+	m.SetAccept(k(1), 1, func(prev interface{}, replaced bool) bool {
+		time.Sleep(20 * time.Millisecond)
+		return true
+	})
+	if len(ops) != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, len(ops))
+	}
+	op := ops[0]
+	if op.Op != "SetAccept" || op.Key != k(1) || !op.Write ||
+		op.Shard != m.choose(k(1)) || op.Held < 20*time.Millisecond {
+		t.Fatalf("unexpected '%+v'", op)
+	}
+	var n int
+	m.Range(func(key string, value interface{}) bool {
+		if n == 0 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		n++
+		return true
+	})
+	if len(ops) != 2 {
+		t.Fatalf("expected '%v', got '%v'", 2, len(ops))
+	}
+	op = ops[1]
+	if op.Op != "Range" || op.Key != "" || op.Write ||
+		op.Held < 20*time.Millisecond {
+		t.Fatalf("unexpected '%+v'", op)
+	}
+}
+
+func TestSlowOpNames(t *testing.T) {
+	var ops []OpInfo
+	m := NewOptions(&Options{
+		TrackMeta:       true,
+		SlowOpThreshold: 10 * time.Millisecond,
+		OnSlowOp: func(op OpInfo) {
+			ops = append(ops, op)
+		},
+	})
+	m.SetAcceptMeta("a", 1, func(interface{}, Meta, bool) bool {
+		time.Sleep(20 * time.Millisecond)
+		return true
+	})
+	m.Handle("b").Update(func(interface{}, bool) (interface{}, bool) {
+		time.Sleep(20 * time.Millisecond)
+		return 2, true
+	})
+	if len(ops) != 2 {
+		t.Fatalf("expected '%v', got '%v'", 2, len(ops))
+	}
+	if ops[0].Op != "SetAcceptMeta" || ops[0].Key != "a" {
+		t.Fatalf("unexpected '%+v'", ops[0])
+	}
+	if ops[1].Op != "Update" || ops[1].Key != "b" {
+		t.Fatalf("unexpected '%+v'", ops[1])
+	}
+}
+
+func TestSlowBulkOpNames(t *testing.T) {
+	var mu sync.Mutex
+	names := make(map[string]bool)
+	m := NewOptions(&Options{
+		Shards:          4,
+		SlowOpThreshold: 10 * time.Millisecond,
+		OnSlowOp: func(op OpInfo) {
+			mu.Lock()
+			names[op.Op] = true
+			mu.Unlock()
+		},
+	})
+	m.Set("a", 1)
+	slow := func() { time.Sleep(20 * time.Millisecond) }
+	m.Count(func(string, interface{}) bool { slow(); return true })
+	m.Filter(func(string, interface{}) bool { slow(); return true })
+	m.FindKeys(func(interface{}) bool { slow(); return true }, 1)
+	m.Reduce(func(acc interface{}, key string, value interface{}) interface{} {
+		slow()
+		return acc
+	}, func(a, b interface{}) interface{} { return a }, nil)
+	m.RangePrefix("a", func(string, interface{}) bool { slow(); return true })
+	m.RangeUpdate(func(key string, value interface{}) (interface{}, Op) {
+		slow()
+		return value, OpKeep
+	})
+	for _, name := range []string{
+		"Count", "Filter", "FindKeys", "Reduce", "RangePrefix", "RangeUpdate",
+	} {
+		if !names[name] {
+			t.Fatalf("expected '%v' to be reported, got '%v'", name, names)
+		}
+	}
+
+	// every shard is timed from when it was locked, not from when the last
+	// shard was
+	names = make(map[string]bool)
+	m.sched = func(shard int, write bool) {
+		if shard == m.shards-1 {
+			slow()
+		}
+	}
+	m.RangeSnapshot(func(string, interface{}) bool { return true })
+	if !names["RangeSnapshot"] {
+		t.Fatalf("expected '%v' to be reported, got '%v'", "RangeSnapshot",
+			names)
+	}
+}
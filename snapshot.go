@@ -0,0 +1,104 @@
+package shardmap
+
+import (
+	"bufio"
+	"os"
+	"time"
+)
+
+type snapshotter struct {
+	stop chan struct{}
+	done chan struct{}
+	err  error // from the most recent snapshot
+}
+
+// StartSnapshots periodically saves the map to the file at path, using Save.
+// Each snapshot is first written to a temporary file which is then renamed
+// over path, so that path always holds a complete snapshot. Use LoadFromLog
+// to load it back into a map at startup. Any previously started snapshots are
+// stopped first, thus an interval of zero or less only stops them. Does
+// nothing once the map is closed.
+func (m *Map) StartSnapshots(path string, interval time.Duration) {
+	if interval <= 0 {
+		m.StopSnapshots()
+		return
+	}
+	t := time.NewTicker(interval)
+	if !m.snapshotOn(path, t.C, t.Stop) {
+		t.Stop()
+	}
+}
+
+// snapshotOn saves a snapshot to path on every tick, until the snapshots are
+// stopped, and then calls release. Returns false when the map is closed.
+func (m *Map) snapshotOn(
+	path string, tick <-chan time.Time, release func(),
+) bool {
+	m.StopSnapshots()
+	m.snapMu.Lock()
+	defer m.snapMu.Unlock()
+	if m.isClosed() {
+		return false
+	}
+	s := &snapshotter{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	m.snap = s
+	go func() {
+		defer close(s.done)
+		defer release()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-tick:
+				s.err = m.snapshot(path)
+			}
+		}
+	}()
+	return true
+}
+
+// StopSnapshots stops the periodic snapshots started by StartSnapshots. It
+// waits for a snapshot that is in progress to finish, and returns its error
+// if the most recent snapshot failed.
+func (m *Map) StopSnapshots() error {
+	m.snapMu.Lock()
+	defer m.snapMu.Unlock()
+	if m.snap == nil {
+		return nil
+	}
+	close(m.snap.stop)
+	<-m.snap.done
+	err := m.snap.err
+	m.snap = nil
+	return err
+}
+
+// snapshot atomically replaces the file at path with the current entries.
+func (m *Map) snapshot(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = m.Save(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package shardmap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shardmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot")
+
+	var m Map
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprintf("%d", i), fmt.Sprintf("v%d", i))
+	}
+	if err := m.StopSnapshots(); err != nil {
+		t.Fatal(err)
+	}
+	tick := make(chan time.Time)
+	m.snapshotOn(path, tick, func() {})
+	tick <- time.Time{}
+	if err := m.StopSnapshots(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var m2 Map
+	if err := m2.LoadFromLog(f); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Equal(&m2, nil) {
+		t.Fatal("expected equal maps")
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("expected temporary file to be removed")
+	}
+
+	// unencodable values fail the snapshot
+	m.Set("bad", 1)
+	m.snapshotOn(path, tick, func() {})
+	tick <- time.Time{}
+	if err := m.StopSnapshots(); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// a non-positive interval stops the snapshots rather than panicking
+	m.StartSnapshots(path, time.Hour)
+	if m.snap == nil {
+		t.Fatal("expected snapshots")
+	}
+	m.StartSnapshots(path, 0)
+	if m.snap != nil {
+		t.Fatal("expected no snapshots")
+	}
+	m.Close()
+	m.StartSnapshots(path, time.Hour)
+	if m.snap != nil {
+		t.Fatal("expected no snapshots once closed")
+	}
+}
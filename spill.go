@@ -0,0 +1,231 @@
+package shardmap
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Store is an external key/value store, such as a file or an embedded
+// database, that cold entries are spilled to. See Options.Spill. It's called
+// from multiple goroutines at once, and must be thread-safe.
+type Store interface {
+	Get(key string) (data []byte, ok bool, err error)
+	Set(key string, data []byte) error
+	Delete(key string) error
+}
+
+// spiller moves the coldest entries of each shard to a Store once the shard
+// holds more than its share of Options.SpillLen. Entries are picked using
+// the second chance algorithm: every entry has a referenced bit that's set
+// when it's read, and an entry whose bit is set is passed over once, and
+// then has its bit cleared.
+type spiller struct {
+	store  Store
+	limit  int // in memory entries per shard
+	shards []spillShard
+	mu     sync.Mutex
+	err    error
+	fails  int64 // number of store errors, see Checked
+	last   error // the most recent store error
+}
+
+type spillShard struct {
+	queue   []string          // in memory keys, oldest first
+	head    int               // start of the queue
+	refs    map[string]*int32 // in memory keys -> referenced bit
+	spilled map[string]bool   // keys that are in the store
+}
+
+func newSpiller(store Store, spillLen, shards int) *spiller {
+	s := &spiller{store: store, shards: make([]spillShard, shards)}
+	if s.limit = spillLen / shards; s.limit < 1 {
+		s.limit = 1
+	}
+	for i := range s.shards {
+		s.shards[i].reset()
+	}
+	return s
+}
+
+func (s *spillShard) reset() {
+	*s = spillShard{
+		refs:    make(map[string]*int32),
+		spilled: make(map[string]bool),
+	}
+}
+
+func (s *spillShard) spilledLen() int {
+	return len(s.spilled)
+}
+
+func (s *spillShard) push(key string, ref int32) {
+	if s.head > len(s.queue)/2 && s.head > 64 {
+		s.queue = append(s.queue[:0], s.queue[s.head:]...)
+		s.head = 0
+	}
+	s.queue = append(s.queue, key)
+	if p := s.refs[key]; p != nil {
+		*p = ref
+	} else {
+		s.refs[key] = &ref
+	}
+}
+
+func (s *spiller) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.fails++
+	s.last = err
+	s.mu.Unlock()
+}
+
+// touch marks a key in a read locked shard as recently used.
+func (s *spiller) touch(shard int, key string) {
+	if p := s.shards[shard].refs[key]; p != nil && atomic.LoadInt32(p) == 0 {
+		atomic.StoreInt32(p, 1)
+	}
+}
+
+// spilled returns true when a key in a read locked shard is in the store.
+func (m *Map) spilled(shard int, key string) bool {
+	return m.spill != nil && m.spill.shards[shard].spilled[key]
+}
+
+// added tracks a new key in a write locked shard.
+func (m *Map) added(shard int, key string) {
+	m.spill.shards[shard].push(key, 0)
+	m.evict(shard)
+}
+
+// evict spills the coldest keys of a write locked shard until the shard is
+// within its limit.
+func (m *Map) evict(shard int) {
+	s := &m.spill.shards[shard]
+	for len(s.refs) > m.spill.limit && s.head < len(s.queue) {
+		key := s.queue[s.head]
+		s.head++
+		p := s.refs[key]
+		if p == nil {
+			// deleted, or already seen
+			continue
+		}
+		if atomic.SwapInt32(p, 0) == 1 {
+			s.push(key, 0)
+			continue
+		}
+		value, _ := m.maps[shard].Get(key)
+		data, err := m.codec.encode(value)
+		if err == nil {
+			err = m.spill.store.Set(key, data)
+		}
+		if err != nil {
+			// keep it in memory
+			m.spill.fail(err)
+			s.push(key, 0)
+			return
+		}
+		m.maps[shard].Delete(key)
+		if m.index != nil {
+			m.index[shard].remove(key)
+		}
+		delete(s.refs, key)
+		s.spilled[key] = true
+	}
+}
+
+// removed stops tracking a key that was deleted from a write locked shard.
+func (m *Map) removed(shard int, key string) {
+	delete(m.spill.shards[shard].refs, key)
+}
+
+// promote moves a key in a write locked shard from the store back into
+// memory, if it was spilled.
+func (m *Map) promote(shard int, key string) {
+	if !m.spilled(shard, key) {
+		return
+	}
+	s := &m.spill.shards[shard]
+	delete(s.spilled, key)
+	data, ok, err := m.spill.store.Get(key)
+	if err == nil && ok {
+		var value interface{}
+		if value, err = m.codec.decode(data); err == nil {
+			m.maps[shard].Set(key, value)
+			m.vers[shard]++
+			if m.index != nil {
+				m.index[shard].insert(key)
+			}
+			s.push(key, 1)
+			err = m.spill.store.Delete(key)
+			m.evict(shard)
+		}
+	}
+	if err != nil {
+		m.spill.fail(err)
+	}
+}
+
+// getSpilled returns a value for a key that has been spilled to the store.
+func (m *Map) getSpilled(shard int, key string) (value interface{}, ok bool) {
+	t := m.lock(shard)
+	m.promote(shard, key)
+	value, ok = m.lookup(shard, key)
+	m.unlock(shard, t)
+	return value, ok
+}
+
+// rangeSpilled calls "iter" with the encoded value of every spilled key of a
+// locked shard, as read from the store. Expired keys are skipped, like
+// rangeShard does.
+func (m *Map) rangeSpilled(
+	shard int, iter func(key string, data []byte) bool,
+) error {
+	if m.spill == nil {
+		return nil
+	}
+	now := m.now()
+	for key := range m.spill.shards[shard].spilled {
+		if m.expiredAt(shard, key, now) {
+			continue
+		}
+		data, ok, err := m.spill.store.Get(key)
+		if err != nil {
+			return err
+		}
+		if ok && !iter(key, data) {
+			break
+		}
+	}
+	return nil
+}
+
+// spilledEntries returns all spilled entries of a locked shard, as read from
+// the store.
+func (m *Map) spilledEntries(shard int) ([]Entry, error) {
+	var entries []Entry
+	var derr error
+	err := m.rangeSpilled(shard, func(key string, data []byte) bool {
+		var value interface{}
+		value, derr = m.codec.decode(data)
+		entries = append(entries, Entry{key, value})
+		return derr == nil
+	})
+	if err == nil {
+		err = derr
+	}
+	return entries, err
+}
+
+// resetSpill deletes all of the spilled keys of a write locked shard from the
+// store.
+func (m *Map) resetSpill(shard int) {
+	s := &m.spill.shards[shard]
+	for key := range s.spilled {
+		if err := m.spill.store.Delete(key); err != nil {
+			m.spill.fail(err)
+		}
+	}
+	s.reset()
+}
@@ -0,0 +1,212 @@
+package shardmap
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testStore struct {
+	mu   sync.Mutex
+	data map[string][]byte
+	fail bool
+}
+
+func (s *testStore) Get(key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.data[key]
+	return data, ok, nil
+}
+
+func (s *testStore) Set(key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("store failed")
+	}
+	s.data[key] = data
+	return nil
+}
+
+func (s *testStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+	return nil
+}
+
+func (s *testStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.data)
+}
+
+func TestSpill(t *testing.T) {
+	store := &testStore{data: make(map[string][]byte)}
+	m := NewOptions(&Options{Spill: store, SpillLen: 64, Shards: 4})
+	for i := 0; i < 1000; i++ {
+		m.Set(k(i), k(i))
+	}
+	var n int
+	m.Range(func(key string, value interface{}) bool {
+		n++
+		return true
+	})
+	if n != 64 || store.len() != 1000-64 {
+		t.Fatalf("expected '%v/%v', got '%v/%v'", 64, 1000-64, n, store.len())
+	}
+	if m.Len() != 1000 {
+		t.Fatalf("expected '%v', got '%v'", 1000, m.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		if v, ok := m.Get(k(i)); !ok || v != k(i) {
+			t.Fatalf("expected '%v', got '%v'", k(i), v)
+		}
+	}
+	if prev, replaced := m.Set(k(0), "x"); !replaced || prev != k(0) {
+		t.Fatalf("expected '%v', got '%v'", k(0), prev)
+	}
+	// spilled keys are deleted from the store
+	for i := 1; i < 500; i++ {
+		if prev, deleted := m.Delete(k(i)); !deleted || prev != k(i) {
+			t.Fatalf("expected '%v', got '%v'", k(i), prev)
+		}
+	}
+	n = 0
+	m.Range(func(key string, value interface{}) bool {
+		n++
+		return true
+	})
+	if m.Len() != 501 || n > 64 || n+store.len() != 501 {
+		t.Fatalf("expected '%v', got '%v/%v'", 501, n, store.len())
+	}
+	m.Clear()
+	if m.Len() != 0 || store.len() != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, store.len())
+	}
+	if m.Err() != nil {
+		t.Fatal(m.Err())
+	}
+	store.fail = true
+	for i := 0; i < 100; i++ {
+		m.Set(k(i), k(i))
+	}
+	if m.Err() == nil {
+		t.Fatal("expected an error")
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := m.Get(k(i)); !ok || v != k(i) {
+			t.Fatalf("expected '%v', got '%v'", k(i), v)
+		}
+	}
+}
+
+func TestSpillDrainAndSave(t *testing.T) {
+	store := &testStore{data: make(map[string][]byte)}
+	m := NewOptions(&Options{Spill: store, SpillLen: 64, Shards: 4})
+	for i := 0; i < 1000; i++ {
+		m.Set(k(i), k(i))
+	}
+	var buf bytes.Buffer
+	if err := m.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var m2 Map
+	if err := m2.LoadFromLog(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if m2.Len() != 1000 {
+		t.Fatalf("expected '%v', got '%v'", 1000, m2.Len())
+	}
+	// promoting a spilled key changes the view
+	var key string
+	for key = range store.data {
+		break
+	}
+	v := m.ReadView()
+	if _, ok := v.Get(key); ok {
+		t.Fatal("expected false")
+	}
+	m.Get(key)
+	if v, ok := m.RefreshView().Get(key); !ok || v != key {
+		t.Fatalf("expected '%v', got '%v'", key, v)
+	}
+	drained := make(map[string]interface{})
+	m.Drain(func(key string, value interface{}) {
+		drained[key] = value
+	})
+	if len(drained) != 1000 || m.Len() != 0 || store.len() != 0 {
+		t.Fatalf("expected '%v/%v/%v', got '%v/%v/%v'",
+			1000, 0, 0, len(drained), m.Len(), store.len())
+	}
+	for i := 0; i < 1000; i++ {
+		if drained[k(i)] != k(i) {
+			t.Fatalf("expected '%v', got '%v'", k(i), drained[k(i)])
+		}
+	}
+}
+
+func TestSpillTTLAndEqual(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	store := &testStore{data: make(map[string][]byte)}
+	m := NewOptions(&Options{
+		Spill: store, SpillLen: 8, Shards: 1, Clock: clock,
+	})
+	for i := 0; i < 50; i++ {
+		m.SetTTL("hour"+k(i), "v", time.Hour)
+		m.SetTTL("minute"+k(i), "v", time.Minute)
+		m.Set("plain"+k(i), "v")
+	}
+	if !m.spilled(0, "hour"+k(0)) || !m.spilled(0, "minute"+k(0)) {
+		t.Fatal("expected spilled keys")
+	}
+	clock.Add(time.Minute * 2)
+	var buf bytes.Buffer
+	if err := m.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	m2 := NewOptions(&Options{Clock: clock})
+	if err := m2.LoadFromLog(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if m2.Len() != 100 {
+		t.Fatalf("expected '%v', got '%v'", 100, m2.Len())
+	}
+	for i := 0; i < 50; i++ {
+		if ttl, ok := m2.TTL("hour" + k(i)); !ok || ttl <= 0 {
+			t.Fatalf("expected a ttl, got '%v'", ttl)
+		}
+		if _, ok := m2.Get("minute" + k(i)); ok {
+			t.Fatal("expected false")
+		}
+	}
+	if !m.Equal(m2, nil) {
+		t.Fatal("expected equal maps")
+	}
+
+	// maps that only differ in a spilled value
+	a := NewOptions(&Options{
+		Spill: &testStore{data: make(map[string][]byte)}, SpillLen: 8,
+		Shards: 1,
+	})
+	b := NewOptions(&Options{
+		Spill: &testStore{data: make(map[string][]byte)}, SpillLen: 8,
+		Shards: 1,
+	})
+	for i := 0; i < 100; i++ {
+		a.Set(k(i), k(i))
+		b.Set(k(i), k(i))
+	}
+	b.Set(k(0), "x")
+	a.Set(k(99), "y")
+	b.Set(k(99), "y")
+	if !a.spilled(0, k(0)) || a.Len() != b.Len() {
+		t.Fatal("expected a spilled key")
+	}
+	if a.Equal(b, nil) {
+		t.Fatal("expected different maps")
+	}
+}
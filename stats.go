@@ -0,0 +1,151 @@
+package shardmap
+
+import (
+	"fmt"
+	"math"
+)
+
+// Stats contains information about a map.
+type Stats struct {
+	// Len is the number of values in map.
+	Len int
+	// Shards is the number of shards.
+	Shards int
+	// Distribution summarizes how the values are spread over the shards.
+	Distribution Histogram
+	// Cost is the total cost of all entries. See SetWithCost.
+	Cost int64
+	// Pinned is the number of pinned entries. See Pin.
+	Pinned int
+}
+
+// ProbeStats describes how far the keys of the shard maps are from where their
+// hashes place them, which grows as the tables fill up and as hashes collide.
+type ProbeStats struct {
+	// Keys is the number of keys that were looked up.
+	Keys int
+	// Probes is the total number of groups of slots that were visited while
+	// looking up every key once. Probes/Keys is the mean probe length.
+	Probes int
+	// MaxProbes is the most groups that were visited for a single key.
+	MaxProbes int
+	// FalseMatches is the number of times that looking up a key matched the
+	// short hash of another key, each of which costs a comparison.
+	FalseMatches int
+	// Collisions is the number of keys whose full hash, 64 or 128 bits, is
+	// equal to that of another key in the same shard.
+	Collisions int
+}
+
+// ProbeStater is implemented by shard maps that report ProbeStats, such as
+// NewSwiss and NewSwiss128, and the tables used for SeqLockReads. The latter
+// visit one slot at a time, and store no short hash, so every other key that
+// is compared counts as a false match.
+type ProbeStater interface {
+	ProbeStats() ProbeStats
+}
+
+// ProbeStats returns the ProbeStats of all shards added up, with MaxProbes
+// being the highest of any shard. This looks up every key in the map, with
+// each shard read locked in turn.
+// Returns false when the shard maps don't implement ProbeStater, such as the
+// default robinhood hashmap, which doesn't expose its internals.
+func (m *Map) ProbeStats() (ProbeStats, bool) {
+	m.initDo()
+	var ps ProbeStats
+	for i := 0; i < m.shards; i++ {
+		t := m.rlock(i)
+		p, ok := m.maps[i].(ProbeStater)
+		var s ProbeStats
+		if ok {
+			s = p.ProbeStats()
+		}
+		m.runlock(i, t)
+		if !ok {
+			return ProbeStats{}, false
+		}
+		ps.Keys += s.Keys
+		ps.Probes += s.Probes
+		ps.FalseMatches += s.FalseMatches
+		ps.Collisions += s.Collisions
+		if s.MaxProbes > ps.MaxProbes {
+			ps.MaxProbes = s.MaxProbes
+		}
+	}
+	return ps, true
+}
+
+// Histogram summarizes the number of entries per shard. A standard deviation
+// that is much larger than the square root of the mean points to keys that
+// hash unevenly.
+type Histogram struct {
+	Min    int
+	Max    int
+	Mean   float64
+	StdDev float64
+}
+
+func (h Histogram) String() string {
+	return fmt.Sprintf("min %d, max %d, mean %.1f, stddev %.1f",
+		h.Min, h.Max, h.Mean, h.StdDev)
+}
+
+// Stats returns information about the map.
+func (m *Map) Stats() Stats {
+	dist := m.Distribution()
+	var n int
+	for _, count := range dist {
+		n += count
+	}
+	return Stats{
+		Len:          n,
+		Shards:       len(dist),
+		Distribution: histogram(dist),
+		Cost:         m.Cost(),
+		Pinned:       m.pinnedLen(),
+	}
+}
+
+// Distribution returns the number of entries in each shard, counted the same
+// way as Len.
+func (m *Map) Distribution() []int {
+	m.initDo()
+	dist := make([]int, m.shards)
+	for i := 0; i < m.shards; i++ {
+		t := m.rlock(i)
+		dist[i] = m.shardLen(i)
+		m.runlock(i, t)
+	}
+	return dist
+}
+
+// Histogram returns a summary of the number of entries per shard.
+func (m *Map) Histogram() Histogram {
+	return histogram(m.Distribution())
+}
+
+func histogram(dist []int) Histogram {
+	var h Histogram
+	if len(dist) == 0 {
+		return h
+	}
+	h.Min, h.Max = dist[0], dist[0]
+	var sum float64
+	for _, count := range dist {
+		if count < h.Min {
+			h.Min = count
+		}
+		if count > h.Max {
+			h.Max = count
+		}
+		sum += float64(count)
+	}
+	h.Mean = sum / float64(len(dist))
+	var sq float64
+	for _, count := range dist {
+		d := float64(count) - h.Mean
+		sq += d * d
+	}
+	h.StdDev = math.Sqrt(sq / float64(len(dist)))
+	return h
+}
@@ -0,0 +1,59 @@
+package shardmap
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	var m Map
+	for i := 0; i < 10000; i++ {
+		m.Set(fmt.Sprintf("%d", i), i)
+	}
+	dist := m.Distribution()
+	var n int
+	for _, count := range dist {
+		n += count
+	}
+	if n != 10000 {
+		t.Fatalf("expected '%v', got '%v'", 10000, n)
+	}
+	stats := m.Stats()
+	if stats.Len != 10000 || stats.Shards != len(dist) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	h := stats.Distribution
+	if h.Min > h.Max || float64(h.Min) > h.Mean || h.Mean > float64(h.Max) {
+		t.Fatalf("unexpected histogram %v", h)
+	}
+	if h.Mean != 10000/float64(len(dist)) {
+		t.Fatalf("expected '%v', got '%v'", 10000/float64(len(dist)), h.Mean)
+	}
+	h = histogram([]int{2, 4, 4, 4, 5, 5, 7, 9})
+	if h.Min != 2 || h.Max != 9 || h.Mean != 5 || h.StdDev != 2 {
+		t.Fatalf("unexpected histogram %v", h)
+	}
+	if h.String() != "min 2, max 9, mean 5.0, stddev 2.0" {
+		t.Fatalf("unexpected string '%v'", h.String())
+	}
+}
+
+func TestStatsLen(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	m := NewOptions(&Options{
+		Clock: clock, Spill: &testStore{data: make(map[string][]byte)},
+		SpillLen: 8, Shards: 1,
+	})
+	for i := 0; i < 50; i++ {
+		m.Set(k(i), "v")
+		m.SetTTL("ttl"+k(i), "v", time.Minute)
+	}
+	clock.Add(time.Minute)
+	if s := m.Stats(); s.Len != m.Len() || s.Len != 50 {
+		t.Fatalf("expected '%v', got '%v'", m.Len(), s.Len)
+	}
+	if d := m.Distribution(); d[0] != 50 {
+		t.Fatalf("expected '%v', got '%v'", 50, d[0])
+	}
+}
@@ -0,0 +1,54 @@
+package shardmap
+
+// Stream sends all entries over the returned channel, which is closed after
+// the last entry. The shards are copied by up to GOMAXPROCS goroutines, or by
+// a single goroutine in turn in the small build, each holding the shard read
+// lock only while copying, so a slow receiver pauses the iteration without
+// blocking writers. Entries that are written during the stream may or may not
+// be included. The channel must be drained, otherwise the goroutines are
+// never released.
+func (m *Map) Stream(buffer int) <-chan Entry {
+	m.initDo()
+	ch := make(chan Entry, buffer)
+	go func() {
+		m.parallel(func(shard int) {
+			t := m.rlock(shard)
+			entries := make([]Entry, 0, m.maps[shard].Len())
+			m.rangeShard(shard, func(key string, value interface{}) bool {
+				entries = append(entries, Entry{key, value})
+				return true
+			})
+			m.runlock(shard, t)
+			for _, e := range entries {
+				ch <- e
+			}
+		})
+		close(ch)
+	}()
+	return ch
+}
+
+// Consume assigns all entries received from ch, until it's closed. Entries
+// are grouped by shard, in batches, and each shard is locked once per batch.
+// Returns the number of entries that were received.
+func (m *Map) Consume(ch <-chan Entry) int {
+	m.initDo()
+	const batchSize = 4096
+	groups := make([][]Entry, m.shards)
+	var n, pending int
+	for e := range ch {
+		shard := m.choose(e.Key)
+		groups[shard] = append(groups[shard], e)
+		n++
+		pending++
+		// flush when the batch is full, or when the sender is idle
+		if pending >= batchSize || len(ch) == 0 {
+			m.setGroups(groups, nil)
+			pending = 0
+		}
+	}
+	if pending > 0 {
+		m.setGroups(groups, nil)
+	}
+	return n
+}
@@ -0,0 +1,26 @@
+package shardmap
+
+import "testing"
+
+func TestStream(t *testing.T) {
+	var m Map
+	for i := 0; i < 10000; i++ {
+		m.Set(k(i), i)
+	}
+	var other Map
+	if n := other.Consume(m.Stream(16)); n != 10000 {
+		t.Fatalf("expected '%v', got '%v'", 10000, n)
+	}
+	if !m.Equal(&other, nil) {
+		t.Fatal("expected equal maps")
+	}
+	// writing to the map while streaming must not block
+	var n int
+	for e := range m.Stream(0) {
+		m.Set(e.Key, e.Value)
+		n++
+	}
+	if n != 10000 {
+		t.Fatalf("expected '%v', got '%v'", 10000, n)
+	}
+}
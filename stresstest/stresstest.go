@@ -0,0 +1,232 @@
+// Package stresstest hammers a shardmap.Map from many goroutines at once,
+// using most of its API, and checks that the map stays consistent. Run it
+// with "go test -race" to catch data races in the map, and in the options and
+// callbacks that your own code passes to it.
+//
+//	func TestMapStress(t *testing.T) {
+//		stresstest.Run(t, shardmap.NewOptions(&shardmap.Options{...}), nil)
+//	}
+package stresstest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tidwall/shardmap"
+)
+
+// Options for Run.
+type Options struct {
+	// Goroutines is the number of goroutines. The default is GOMAXPROCS*4.
+	Goroutines int
+	// Ops is the number of operations per goroutine. The default is 10000.
+	Ops int
+	// Keys is the number of keys per goroutine. The default is 100.
+	Keys int
+	// Seed seeds the operations. The default is 1.
+	Seed int64
+}
+
+// Run stresses the map. Each goroutine owns a set of keys, which only it
+// writes to, and which it checks after every operation. All goroutines also
+// write to a set of shared keys, and read the whole map, concurrently. The
+// map must be empty, and must not be used elsewhere until Run returns. Values
+// are strings, so that maps with a log can be used.
+//
+// Failures are reported using t.Errorf.
+func Run(t testing.TB, m *shardmap.Map, opts *Options) {
+	t.Helper()
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.Goroutines <= 0 {
+		o.Goroutines = runtime.GOMAXPROCS(0) * 4
+	}
+	if o.Ops <= 0 {
+		o.Ops = 10000
+	}
+	if o.Keys <= 0 {
+		o.Keys = 100
+	}
+	if o.Seed == 0 {
+		o.Seed = 1
+	}
+	if n := m.Len(); n != 0 {
+		t.Errorf("stresstest: expected an empty map, got %d entries", n)
+		return
+	}
+	owned := make([]map[string]string, o.Goroutines)
+	var wg sync.WaitGroup
+	for g := 0; g < o.Goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			w := &worker{
+				t:      t,
+				m:      m,
+				rnd:    rand.New(rand.NewSource(o.Seed + int64(g))),
+				prefix: fmt.Sprintf("g%d:", g),
+				keys:   o.Keys,
+				exp:    make(map[string]string),
+			}
+			for i := 0; i < o.Ops && w.step(i); i++ {
+			}
+			owned[g] = w.exp
+		}(g)
+	}
+	wg.Wait()
+	// every owned key must match, and nothing else may be left over besides
+	// the shared keys
+	var n int
+	for g, exp := range owned {
+		prefix := fmt.Sprintf("g%d:", g)
+		for key, value := range exp {
+			if v, ok := m.Get(key); !ok || v != value {
+				t.Errorf("stresstest: key %q: expected %q, got %v", key, value, v)
+			}
+		}
+		m.RangePrefix(prefix, func(key string, value interface{}) bool {
+			if _, ok := exp[key]; !ok {
+				t.Errorf("stresstest: unexpected key %q", key)
+			}
+			return true
+		})
+		n += len(exp)
+	}
+	m.Range(func(key string, value interface{}) bool {
+		if strings.HasPrefix(key, "s:") {
+			n++
+		}
+		return true
+	})
+	if l := m.Len(); l != n {
+		t.Errorf("stresstest: expected %d entries, got %d", n, l)
+	}
+}
+
+type worker struct {
+	t      testing.TB
+	m      *shardmap.Map
+	rnd    *rand.Rand
+	prefix string
+	keys   int
+	exp    map[string]string // expected values of the owned keys
+}
+
+func (w *worker) fail(format string, args ...interface{}) bool {
+	w.t.Errorf("stresstest: "+format, args...)
+	return false
+}
+
+// check compares a previous value returned by the map with the expected one.
+func (w *worker) check(op, key string, prev interface{}, ok bool) bool {
+	exp, eok := w.exp[key]
+	if ok != eok || (ok && prev != exp) {
+		return w.fail("%s %q: expected %q/%v, got %v/%v",
+			op, key, exp, eok, prev, ok)
+	}
+	return true
+}
+
+// step performs one random operation, and returns false on failure.
+func (w *worker) step(i int) bool {
+	m := w.m
+	key := fmt.Sprintf("%s%d", w.prefix, w.rnd.Intn(w.keys))
+	shared := fmt.Sprintf("s:%d", w.rnd.Intn(w.keys))
+	value := fmt.Sprintf("%s%d", w.prefix, i)
+	switch w.rnd.Intn(16) {
+	case 0, 1, 2:
+		prev, ok := m.Set(key, value)
+		if !w.check("set", key, prev, ok) {
+			return false
+		}
+		w.exp[key] = value
+	case 3, 4:
+		prev, ok := m.Get(key)
+		if !w.check("get", key, prev, ok) {
+			return false
+		}
+	case 5:
+		prev, ok := m.Delete(key)
+		if !w.check("delete", key, prev, ok) {
+			return false
+		}
+		delete(w.exp, key)
+	case 6:
+		accept := w.rnd.Intn(2) == 0
+		prev, ok := m.SetAccept(key, value,
+			func(prev interface{}, replaced bool) bool {
+				w.check("set accept", key, prev, replaced)
+				return accept
+			})
+		if accept {
+			if !w.check("set accept", key, prev, ok) {
+				return false
+			}
+			w.exp[key] = value
+		}
+	case 7:
+		prev, ok := m.DeleteAccept(key,
+			func(prev interface{}, deleted bool) bool {
+				return true
+			})
+		if !w.check("delete accept", key, prev, ok) {
+			return false
+		}
+		delete(w.exp, key)
+	case 8:
+		b := m.NewBatch()
+		b.Set(key, value)
+		b.Set(shared, value)
+		b.Delete(key)
+		b.Set(key, value)
+		b.Apply()
+		w.exp[key] = value
+	case 9:
+		m.Set(shared, value)
+	case 10:
+		m.Delete(shared)
+	case 11:
+		// the owned keys must be exactly what's expected
+		var n int
+		m.RangePrefix(w.prefix, func(k string, v interface{}) bool {
+			n++
+			if exp, ok := w.exp[k]; !ok || v != exp {
+				w.fail("range prefix %q: expected %q, got %v", k, exp, v)
+			}
+			return true
+		})
+		if n != len(w.exp) {
+			return w.fail("range prefix: expected %d keys, got %d",
+				len(w.exp), n)
+		}
+	case 12:
+		m.Range(func(key string, value interface{}) bool {
+			return w.rnd.Intn(100) != 0
+		})
+		m.Len()
+	case 13:
+		m.RangeSnapshot(func(key string, value interface{}) bool {
+			return true
+		})
+		m.Sample(3)
+	case 14:
+		m.Count(func(key string, value interface{}) bool {
+			return strings.HasPrefix(key, "s:")
+		})
+		m.FindKeys(func(v interface{}) bool {
+			return strings.HasPrefix(v.(string), w.prefix)
+		}, 2)
+	case 15:
+		m.Stats()
+		m.Save(ioutil.Discard)
+		m.ReadView().Get(shared)
+	}
+	return true
+}
@@ -0,0 +1,24 @@
+package stresstest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tidwall/shardmap"
+)
+
+func TestRun(t *testing.T) {
+	opts := &Options{Goroutines: 8, Ops: 2000}
+	for name, mopts := range map[string]*shardmap.Options{
+		"default":  nil,
+		"oneshard": {Shards: 1},
+		"swiss":    {NewShardMap: shardmap.NewSwiss, InternKeys: true},
+		"indexed":  {IndexKeys: true, TrackMeta: true},
+		"queue":    {WriteQueue: 16, Shards: 4},
+		"log":      {Log: new(bytes.Buffer)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			Run(t, shardmap.NewOptions(mopts), opts)
+		})
+	}
+}
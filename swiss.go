@@ -0,0 +1,277 @@
+package shardmap
+
+import (
+	"math/bits"
+
+	"github.com/cespare/xxhash"
+)
+
+// Control bytes. A full slot stores the high 7 bits of its hash (h2), which
+// always has the high bit unset.
+const (
+	swissEmpty   = 0x80
+	swissDeleted = 0xFE
+	swissLSBs    = 0x0101010101010101
+	swissMSBs    = 0x8080808080808080
+	swissGroup   = 8 // slots per group, one control byte each
+)
+
+type swissSlot struct {
+	key   string
+	value interface{}
+}
+
+// swissMap is an open addressing hashmap in the style of a SwissTable. Slots
+// are arranged in groups of eight, and each group has a word of control bytes
+// that is probed eight slots at a time, using SWAR bit tricks in place of
+// SIMD instructions.
+type swissMap struct {
+	ctrl   []uint64    // one word of control bytes per group
+	slots  []swissSlot // len(ctrl)*swissGroup
+	hashes []uint64    // low half of the 128-bit hash per slot, when wide
+	wide   bool
+	mask   uint64 // len(ctrl)-1
+	count  int    // number of full slots
+	dead   int    // number of deleted slots
+	grow   int    // rehash when count+dead reaches this
+}
+
+// NewSwiss returns a SwissTable-style hashmap for use as the backing map of a
+// shard. It's generally faster than the default for read-heavy workloads with
+// a high load factor.
+//
+//	m := shardmap.NewOptions(&shardmap.Options{
+//		NewShardMap: shardmap.NewSwiss,
+//	})
+func NewSwiss(cap int) ShardMap {
+	return newSwiss(cap, false)
+}
+
+// NewSwiss128 is like NewSwiss, but it hashes keys using Hash128. The high
+// half places the key in the table like NewSwiss, and is the same hash that
+// the map uses to pick the shard. The low half is stored alongside each key
+// and compared before the key itself, so that keys which share their short
+// hash only cost a key comparison when all 128 bits are equal. This uses an
+// extra 8 bytes per slot.
+func NewSwiss128(cap int) ShardMap {
+	return newSwiss(cap, true)
+}
+
+func newSwiss(cap int, wide bool) *swissMap {
+	// size for a max load factor of 7/8
+	n := 1
+	for n*swissGroup*7/8 < cap {
+		n *= 2
+	}
+	m := &swissMap{
+		ctrl:  make([]uint64, n),
+		slots: make([]swissSlot, n*swissGroup),
+		wide:  wide,
+		mask:  uint64(n - 1),
+		grow:  n * swissGroup * 7 / 8,
+	}
+	if wide {
+		m.hashes = make([]uint64, n*swissGroup)
+	}
+	for i := range m.ctrl {
+		m.ctrl[i] = swissLSBs * swissEmpty
+	}
+	return m
+}
+
+// swissMatch returns a mask with the high bit set for each control byte that
+// equals h2. There may be false positives, which are weeded out by comparing
+// keys.
+func swissMatch(w uint64, h2 uint8) uint64 {
+	x := w ^ (swissLSBs * uint64(h2))
+	return (x - swissLSBs) &^ x & swissMSBs
+}
+
+// swissMatchEmpty returns a mask with the high bit set for each empty byte.
+func swissMatchEmpty(w uint64) uint64 {
+	return w &^ (w << 6) & swissMSBs
+}
+
+// swissMatchFree returns a mask with the high bit set for each empty or
+// deleted byte.
+func swissMatchFree(w uint64) uint64 {
+	return w & swissMSBs
+}
+
+func (m *swissMap) setCtrl(i uint64, b uint8) {
+	shift := (i % swissGroup) * 8
+	g := i / swissGroup
+	m.ctrl[g] = m.ctrl[g]&^(0xFF<<shift) | uint64(b)<<shift
+}
+
+// hash returns the hash of a key, and the low half of its 128-bit hash when
+// the map is wide.
+func (m *swissMap) hash(key string) (hash, lo uint64) {
+	if m.wide {
+		return Hash128(key)
+	}
+	return xxhash.Sum64String(key), 0
+}
+
+// split returns the group where the probe for a hash starts, and the h2 that
+// is stored in the control byte. The map picks shards using the low bits of
+// the same hash, thus all keys in a shard share those bits. So h2 is taken
+// from the high bits, and the group from the hash mixed by mix64, which makes
+// every bit of the group depend on every bit of the hash.
+func (m *swissMap) split(hash uint64) (g uint64, h2 uint8) {
+	return mix64(hash) & m.mask, uint8(hash >> 57)
+}
+
+// same returns true when slot j holds the key.
+func (m *swissMap) same(j uint64, key string, lo uint64) bool {
+	return (!m.wide || m.hashes[j] == lo) && m.slots[j].key == key
+}
+
+// find returns the slot index for the key, or -1 if the key does not exist.
+func (m *swissMap) find(key string, hash, lo uint64) int {
+	g, h2 := m.split(hash)
+	for i := uint64(1); ; i++ {
+		w := m.ctrl[g]
+		for b := swissMatch(w, h2); b != 0; b &= b - 1 {
+			j := g*swissGroup + uint64(bits.TrailingZeros64(b)/8)
+			if m.same(j, key, lo) {
+				return int(j)
+			}
+		}
+		if swissMatchEmpty(w) != 0 {
+			return -1
+		}
+		g = (g + i) & m.mask
+	}
+}
+
+// Set assigns a value to a key.
+func (m *swissMap) Set(key string, value interface{}) (
+	prev interface{}, replaced bool,
+) {
+	if m.count+m.dead >= m.grow {
+		m.rehash()
+	}
+	hash, lo := m.hash(key)
+	g, h2 := m.split(hash)
+	ins := -1
+	for i := uint64(1); ; i++ {
+		w := m.ctrl[g]
+		for b := swissMatch(w, h2); b != 0; b &= b - 1 {
+			j := g*swissGroup + uint64(bits.TrailingZeros64(b)/8)
+			if m.same(j, key, lo) {
+				prev = m.slots[j].value
+				m.slots[j].value = value
+				return prev, true
+			}
+		}
+		if ins < 0 {
+			if b := swissMatchFree(w); b != 0 {
+				ins = int(g*swissGroup + uint64(bits.TrailingZeros64(b)/8))
+			}
+		}
+		if swissMatchEmpty(w) != 0 {
+			break
+		}
+		g = (g + i) & m.mask
+	}
+	if uint8(m.ctrl[ins/swissGroup]>>(uint(ins%swissGroup)*8)) == swissDeleted {
+		m.dead--
+	}
+	m.setCtrl(uint64(ins), h2)
+	m.slots[ins] = swissSlot{key, value}
+	if m.wide {
+		m.hashes[ins] = lo
+	}
+	m.count++
+	return nil, false
+}
+
+// Get returns a value for a key.
+func (m *swissMap) Get(key string) (value interface{}, ok bool) {
+	hash, lo := m.hash(key)
+	if i := m.find(key, hash, lo); i >= 0 {
+		return m.slots[i].value, true
+	}
+	return nil, false
+}
+
+// Delete deletes a value for a key.
+func (m *swissMap) Delete(key string) (prev interface{}, deleted bool) {
+	hash, lo := m.hash(key)
+	i := m.find(key, hash, lo)
+	if i < 0 {
+		return nil, false
+	}
+	prev = m.slots[i].value
+	m.slots[i] = swissSlot{}
+	// A probe stops at the first group with an empty slot, so when the group
+	// already has one the slot can be emptied rather than marked deleted.
+	if swissMatchEmpty(m.ctrl[i/swissGroup]) != 0 {
+		m.setCtrl(uint64(i), swissEmpty)
+	} else {
+		m.setCtrl(uint64(i), swissDeleted)
+		m.dead++
+	}
+	m.count--
+	return prev, true
+}
+
+// Len returns the number of values in map.
+func (m *swissMap) Len() int {
+	return m.count
+}
+
+// Range iterates over all key/values.
+func (m *swissMap) Range(iter func(key string, value interface{}) bool) {
+	for g, w := range m.ctrl {
+		for b := ^w & swissMSBs; b != 0; b &= b - 1 {
+			s := &m.slots[g*swissGroup+bits.TrailingZeros64(b)/8]
+			if !iter(s.key, s.value) {
+				return
+			}
+		}
+	}
+}
+
+// rehash moves all entries into a new table that has room for twice as many,
+// which also drops the deleted slots.
+func (m *swissMap) rehash() {
+	nm := newSwiss((m.count+1)*2, m.wide)
+	m.Range(func(key string, value interface{}) bool {
+		nm.Set(key, value)
+		return true
+	})
+	*m = *nm
+}
+
+// ProbeStats looks up every key, and reports how far each one was from where
+// its hash places it.
+func (m *swissMap) ProbeStats() ProbeStats {
+	var ps ProbeStats
+	m.Range(func(key string, value interface{}) bool {
+		hash, lo := m.hash(key)
+		g, h2 := m.split(hash)
+		ps.Keys++
+		for i := uint64(1); ; i++ {
+			ps.Probes++
+			if int(i) > ps.MaxProbes {
+				ps.MaxProbes = int(i)
+			}
+			w := m.ctrl[g]
+			for b := swissMatch(w, h2); b != 0; b &= b - 1 {
+				j := g*swissGroup + uint64(bits.TrailingZeros64(b)/8)
+				if m.same(j, key, lo) {
+					return true
+				}
+				ps.FalseMatches++
+				if ohash, olo := m.hash(m.slots[j].key); ohash == hash &&
+					olo == lo {
+					ps.Collisions++
+				}
+			}
+			g = (g + i) & m.mask
+		}
+	})
+	return ps
+}
@@ -0,0 +1,133 @@
+package shardmap
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSwiss(t *testing.T) {
+	m := newSwiss(0, false)
+	exp := make(map[string]interface{})
+	for i := 0; i < 100000; i++ {
+		key := k(rand.Intn(5000))
+		switch rand.Intn(3) {
+		case 0, 1:
+			prev, replaced := m.Set(key, i)
+			eprev, ereplaced := exp[key]
+			if replaced != ereplaced || prev != eprev {
+				t.Fatalf("expected %v/%v, got %v/%v",
+					eprev, ereplaced, prev, replaced)
+			}
+			exp[key] = i
+		case 2:
+			prev, deleted := m.Delete(key)
+			eprev, edeleted := exp[key]
+			if deleted != edeleted || prev != eprev {
+				t.Fatalf("expected %v/%v, got %v/%v",
+					eprev, edeleted, prev, deleted)
+			}
+			delete(exp, key)
+		}
+		if m.Len() != len(exp) {
+			t.Fatalf("expected %v, got %v", len(exp), m.Len())
+		}
+	}
+	for key, value := range exp {
+		if v, ok := m.Get(key); !ok || v != value {
+			t.Fatalf("expected %v, got %v", value, v)
+		}
+	}
+	var n int
+	m.Range(func(key string, value interface{}) bool {
+		if exp[key] != value {
+			t.Fatalf("expected %v, got %v", exp[key], value)
+		}
+		n++
+		return true
+	})
+	if n != len(exp) {
+		t.Fatalf("expected %v, got %v", len(exp), n)
+	}
+}
+
+func TestSwissOption(t *testing.T) {
+	m := NewOptions(&Options{Cap: 1000, NewShardMap: NewSwiss})
+	for i := 0; i < 1000; i++ {
+		m.Set(k(i), i)
+	}
+	if m.Len() != 1000 {
+		t.Fatalf("expected %v, got %v", 1000, m.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		if v, _ := m.Get(k(i)); v != i {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+	}
+	if _, ok := m.maps[0].(*swissMap); !ok {
+		t.Fatalf("expected swiss shard, got %T", m.maps[0])
+	}
+	if f := m.Filter(func(string, interface{}) bool { return true }); f.Len() != 1000 {
+		t.Fatalf("expected %v, got %v", 1000, f.Len())
+	}
+	m.Clear()
+	if m.Len() != 0 {
+		t.Fatalf("expected %v, got %v", 0, m.Len())
+	}
+}
+
+func TestSwiss128(t *testing.T) {
+	m := NewOptions(&Options{NewShardMap: NewSwiss128})
+	for i := 0; i < 10000; i++ {
+		m.Set(k(i), i)
+	}
+	for i := 0; i < 10000; i += 2 {
+		m.Delete(k(i))
+	}
+	for i := 0; i < 10000; i++ {
+		v, ok := m.Get(k(i))
+		if ok != (i%2 == 1) || (ok && v != i) {
+			t.Fatalf("expected '%v', got '%v'", i, v)
+		}
+	}
+	ps, ok := m.ProbeStats()
+	if !ok || ps.Keys != 5000 || ps.Probes < ps.Keys || ps.MaxProbes < 1 {
+		t.Fatalf("unexpected probe stats %+v", ps)
+	}
+	if ps.Collisions != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, ps.Collisions)
+	}
+	if _, ok := New(0).ProbeStats(); ok {
+		t.Fatal("expected false")
+	}
+}
+
+func TestSwissCollisions(t *testing.T) {
+	// a table with a single group finds every key with the first probe
+	m := newSwiss(0, false)
+	for i := 0; i < 7; i++ {
+		m.Set(k(i), i)
+	}
+	ps := m.ProbeStats()
+	if ps.Keys != 7 || ps.MaxProbes != 1 || ps.Collisions != 0 {
+		t.Fatalf("unexpected probe stats %+v", ps)
+	}
+}
+
+func TestSwissManyShards(t *testing.T) {
+	// all keys in a shard share the low bits of their hash, which must not
+	// make them cluster in the shard's table
+	for _, newShard := range []func(cap int) ShardMap{NewSwiss, NewSwiss128} {
+		m := NewOptions(&Options{NewShardMap: newShard, Shards: 256})
+		for i := 0; i < 100000; i++ {
+			m.Set(k(i), i)
+		}
+		ps, _ := m.ProbeStats()
+		if ps.Keys != 100000 {
+			t.Fatalf("expected '%v', got '%v'", 100000, ps.Keys)
+		}
+		if ps.FalseMatches > ps.Keys/10 || ps.Probes > ps.Keys*3/2 ||
+			ps.MaxProbes > 16 {
+			t.Fatalf("unexpected probe stats %+v", ps)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+//go:build go1.18
+// +build go1.18
+
+package shardmap
+
+// TrySet assigns a value to a key, but only if the shard can be locked right
+// away, without waiting on other goroutines.
+// Returns the previous value, or false when no value was assigned.
+// Returns false for "ok" when the shard was busy and nothing was assigned.
+func (m *Map) TrySet(key string, value interface{}) (
+	prev interface{}, replaced, ok bool,
+) {
+	m.initDo()
+	shard := m.choose(key)
+	t, ok := m.trylock(shard)
+	if !ok {
+		return nil, false, false
+	}
+	prev, replaced = m.set(shard, key, value)
+	m.unlockOp(shard, t, "TrySet", key)
+	return prev, replaced, true
+}
+
+// TryGet returns a value for a key, but only if the shard can be locked
+// right away, without waiting on other goroutines.
+// Returns false for "found" when no value has been assign for key.
+// Returns false for "ok" when the shard was busy.
+func (m *Map) TryGet(key string) (value interface{}, found, ok bool) {
+	m.initDo()
+	shard := m.choose(key)
+	t, ok := m.tryrlock(shard)
+	if !ok {
+		return nil, false, false
+	}
+	value, found = m.lookup(shard, key)
+	spilled := !found && m.spilled(shard, key)
+	m.runlockOp(shard, t, "TryGet", key)
+	if spilled {
+		// loading from the store needs the write lock
+		if t, ok = m.trylock(shard); !ok {
+			return nil, false, false
+		}
+		m.promote(shard, key)
+		value, found = m.lookup(shard, key)
+		m.unlockOp(shard, t, "TryGet", key)
+	}
+	return value, found, true
+}
+
+// TryDelete deletes a value for a key, but only if the shard can be locked
+// right away, without waiting on other goroutines.
+// Returns the deleted value, or false when no value was assigned.
+// Returns false for "ok" when the shard was busy and nothing was deleted.
+func (m *Map) TryDelete(key string) (prev interface{}, deleted, ok bool) {
+	m.initDo()
+	shard := m.choose(key)
+	t, ok := m.trylock(shard)
+	if !ok {
+		return nil, false, false
+	}
+	prev, deleted = m.delete(shard, key)
+	m.unlockOp(shard, t, "TryDelete", key)
+	return prev, deleted, true
+}
+
+// trylock write locks a shard, unless it's already locked.
+func (m *Map) trylock(shard int) (int64, bool) {
+	if !m.mus[shard].TryLock() {
+		return 0, false
+	}
+	m.seqWrite(shard)
+	if !m.timed() {
+		return 0, true
+	}
+	if m.prof != nil {
+		m.prof.acquired(shard, true, 0)
+	}
+	return m.lockTime(), true
+}
+
+// tryrlock read locks a shard, unless it's already write locked.
+func (m *Map) tryrlock(shard int) (int64, bool) {
+	if !m.mus[shard].TryRLock() {
+		return 0, false
+	}
+	if !m.timed() {
+		return 0, true
+	}
+	if m.prof != nil {
+		m.prof.acquired(shard, false, 0)
+	}
+	return m.lockTime(), true
+}
@@ -0,0 +1,38 @@
+//go:build go1.18
+// +build go1.18
+
+package shardmap
+
+import "testing"
+
+func TestTryLock(t *testing.T) {
+	var m Map
+	prev, replaced, ok := m.TrySet("hello", "world")
+	if !ok || replaced || prev != nil {
+		t.Fatalf("expected '%v', got '%v'", nil, prev)
+	}
+	value, found, ok := m.TryGet("hello")
+	if !ok || !found || value != "world" {
+		t.Fatalf("expected '%v', got '%v'", "world", value)
+	}
+	// hold the shard lock while trying
+	m.SetAccept("hello", "planet", func(interface{}, bool) bool {
+		if _, _, ok := m.TryGet("hello"); ok {
+			t.Fatal("expected false")
+		}
+		if _, _, ok := m.TrySet("hello", "moon"); ok {
+			t.Fatal("expected false")
+		}
+		if _, _, ok := m.TryDelete("hello"); ok {
+			t.Fatal("expected false")
+		}
+		return true
+	})
+	prev, deleted, ok := m.TryDelete("hello")
+	if !ok || !deleted || prev != "planet" {
+		t.Fatalf("expected '%v', got '%v'", "planet", prev)
+	}
+	if m.Len() != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, m.Len())
+	}
+}
@@ -0,0 +1,311 @@
+package shardmap
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock provides the current time. Custom clocks let tests move time forward
+// without sleeping, or let servers use a cheaper, coarse clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// expiry is when a key with a TTL expires, in now() nanoseconds, along with
+// the TTL itself, which is reused when a stale key is reloaded.
+type expiry struct {
+	at  int64
+	ttl time.Duration
+}
+
+type sweeper struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// SetTTL assigns a value to a key that expires after ttl. Expired entries are
+// no longer returned by Get, and are deleted by DeleteExpired, by the sweeper
+// when Options.SweepInterval is set, or by the next write to the key. A plain
+// Set removes the expiration. The expiration is written to the log, and is
+// saved by Save and Compact, as the time at which the key expires.
+// With Options.StaleWhileRevalidate, entries expire that much later.
+// Returns the previous value, or false when no value was assigned.
+func (m *Map) SetTTL(key string, value interface{}, ttl time.Duration) (
+	prev interface{}, replaced bool,
+) {
+	m.initDo()
+	shard := m.choose(key)
+	t := m.lock(shard)
+	prev, replaced = m.set(shard, key, value)
+	m.setExpires(shard, key, ttl)
+	m.unlockOp(shard, t, "SetTTL", key)
+	return prev, replaced
+}
+
+// Touch sets the expiration of a key to ttl from now, or removes it when ttl
+// is zero or less.
+// Returns false when no value has been assign for key.
+func (m *Map) Touch(key string, ttl time.Duration) bool {
+	_, ok := m.GetEx(key, ttl)
+	return ok
+}
+
+// GetEx returns a value for a key, and sets its expiration to ttl from now in
+// the same operation, or removes it when ttl is zero or less.
+// Returns false when no value has been assign for key.
+func (m *Map) GetEx(key string, ttl time.Duration) (value interface{}, ok bool) {
+	m.initDo()
+	shard := m.choose(key)
+	t := m.lock(shard)
+	m.expireKey(shard, key)
+	m.promote(shard, key)
+	if value, ok = m.maps[shard].Get(key); ok {
+		m.setExpires(shard, key, ttl)
+	}
+	m.unlockOp(shard, t, "GetEx", key)
+	return value, ok
+}
+
+// TTL returns the time left until a key expires, which is zero once it has
+// expired but is still served as stale, see Options.StaleWhileRevalidate.
+// Returns a negative ttl when the key has no expiration or is pinned, and
+// false when no value has been assign for key.
+func (m *Map) TTL(key string) (ttl time.Duration, ok bool) {
+	m.initDo()
+	shard := m.choose(key)
+	t := m.rlock(shard)
+	defer m.runlockOp(shard, t, "TTL", key)
+	_, ok = m.maps[shard].Get(key)
+	if !ok && !m.spilled(shard, key) || m.expired(shard, key) {
+		return 0, false
+	}
+	e, has := m.expires[shard][key]
+	if !has || m.pins[shard][key] {
+		return -1, true
+	}
+	if ttl = time.Duration(e.at - m.now()); ttl < 0 {
+		ttl = 0
+	}
+	return ttl, true
+}
+
+// DeleteExpired deletes all expired entries.
+// Returns the number of entries that were deleted.
+func (m *Map) DeleteExpired() int {
+	m.initDo()
+	var n int
+	var keys []string
+	for i := 0; i < m.shards; i++ {
+		t := m.lock(i)
+		if len(m.expires[i]) > 0 {
+			now := m.now()
+			keys = keys[:0]
+			for key, e := range m.expires[i] {
+				if e.at+int64(m.grace) <= now {
+					keys = append(keys, key)
+				}
+			}
+			for _, key := range keys {
+				if m.expireKey(i, key) {
+					n++
+				}
+			}
+		}
+		m.unlock(i, t)
+	}
+	return n
+}
+
+// LenExpired returns the number of entries that have expired, but have not
+// been deleted yet, such as by the sweeper. These are not counted by Len.
+func (m *Map) LenExpired() int {
+	m.initDo()
+	var n int
+	for i := 0; i < m.shards; i++ {
+		t := m.rlock(i)
+		n += m.expiredLen(i)
+		m.runlock(i, t)
+	}
+	return n
+}
+
+// expiredLen returns the number of expired entries in a locked shard.
+func (m *Map) expiredLen(shard int) int {
+	if len(m.expires[shard]) == 0 {
+		return 0
+	}
+	now := m.now()
+	var n int
+	for key := range m.expires[shard] {
+		if m.expiredAt(shard, key, now) {
+			n++
+		}
+	}
+	return n
+}
+
+// setExpires sets the expiration of a key in a write locked shard, or
+// removes it when ttl is zero or less.
+func (m *Map) setExpires(shard int, key string, ttl time.Duration) {
+	if ttl <= 0 {
+		m.setExpiry(shard, key, expiry{})
+		return
+	}
+	m.setExpiry(shard, key, expiry{m.now() + int64(ttl), ttl})
+}
+
+// setExpiry sets the expiry of a key in a write locked shard, or removes it
+// when its ttl is zero, and writes the change to the log.
+func (m *Map) setExpiry(shard int, key string, e expiry) {
+	if e.ttl <= 0 {
+		if _, ok := m.expires[shard][key]; !ok {
+			return
+		}
+		delete(m.expires[shard], key)
+	} else {
+		m.storeExpiry(shard, key, e)
+	}
+	if m.wal != nil {
+		m.wal.expire(key, m.deadline(e), e.ttl)
+	}
+}
+
+// storeExpiry sets the expiry of a key in a write locked shard, without
+// logging.
+func (m *Map) storeExpiry(shard int, key string, e expiry) {
+	if m.expires[shard] == nil {
+		m.expires[shard] = make(map[string]expiry)
+		atomic.StoreInt32(&m.hasTTL, 1)
+	}
+	m.expires[shard][key] = e
+}
+
+// deadline returns the wall clock time at which an expiry is reached, in
+// Unix nanoseconds, or zero for no expiry. This is how expirations are
+// written to the log.
+func (m *Map) deadline(e expiry) int64 {
+	if e.ttl <= 0 {
+		return 0
+	}
+	return clockEpoch.Add(time.Duration(e.at)).UnixNano()
+}
+
+// expired returns true when the key has expired, and is no longer stale
+// either. Pinned keys never expire. The shard must be locked.
+func (m *Map) expired(shard int, key string) bool {
+	if len(m.expires[shard]) == 0 {
+		return false
+	}
+	return m.expiredAt(shard, key, m.now())
+}
+
+// expiredAt is expired at a given now().
+func (m *Map) expiredAt(shard int, key string, now int64) bool {
+	e, ok := m.expires[shard][key]
+	return ok && e.at+int64(m.grace) <= now && !m.pins[shard][key]
+}
+
+// rangeShard iterates over the key/values of a locked shard, skipping expired
+// entries. All of the functions that scan the map go through here, so that
+// they agree with Get on which entries are there.
+func (m *Map) rangeShard(
+	shard int, iter func(key string, value interface{}) bool,
+) {
+	if len(m.expires[shard]) == 0 {
+		m.maps[shard].Range(iter)
+		return
+	}
+	now := m.now()
+	m.maps[shard].Range(func(key string, value interface{}) bool {
+		if m.expiredAt(shard, key, now) {
+			return true
+		}
+		return iter(key, value)
+	})
+}
+
+// isStale returns the expiry of a key when its TTL has passed, but it's still
+// within Options.StaleWhileRevalidate. The shard must be locked.
+func (m *Map) isStale(shard int, key string) (e expiry, ok bool) {
+	if len(m.expires[shard]) == 0 {
+		return e, false
+	}
+	e, ok = m.expires[shard][key]
+	return e, ok && e.at <= m.now() && !m.pins[shard][key]
+}
+
+// lookup returns a value for a key in a locked shard, unless it has expired.
+func (m *Map) lookup(shard int, key string) (value interface{}, ok bool) {
+	value, ok = m.maps[shard].Get(key)
+	if ok {
+		if m.expired(shard, key) {
+			return nil, false
+		}
+		if m.spill != nil {
+			m.spill.touch(shard, key)
+		}
+	}
+	return value, ok
+}
+
+// expireKey deletes a key from a write locked shard if it has expired.
+// Returns true when the key was deleted.
+func (m *Map) expireKey(shard int, key string) bool {
+	if !m.expired(shard, key) {
+		return false
+	}
+	value, _ := m.remove(shard, key)
+	if m.wal != nil {
+		m.wal.delete(key)
+	}
+	if m.onExpire != nil {
+		m.onExpire(key, value)
+	}
+	return true
+}
+
+// clockNow returns the current time of the map's clock.
+func (m *Map) clockNow() time.Time {
+	if m.clock != nil {
+		return m.clock.Now()
+	}
+	return time.Now()
+}
+
+// clockEpoch is the time that expirations are relative to. Subtracting it
+// uses the monotonic clock reading when there is one, so that expirations are
+// not affected by changes to the system's wall clock.
+var clockEpoch = time.Now()
+
+// now returns the current time of the map's clock, in nanoseconds since
+// clockEpoch.
+func (m *Map) now() int64 {
+	return int64(m.clockNow().Sub(clockEpoch))
+}
+
+func (m *Map) startSweeper(interval time.Duration) {
+	t := time.NewTicker(interval)
+	m.sweepOn(t.C, t.Stop)
+}
+
+// sweepOn deletes the expired entries on every tick, until the sweeper is
+// stopped, and then calls release.
+func (m *Map) sweepOn(tick <-chan time.Time, release func()) {
+	s := &sweeper{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	m.sweep = s
+	go func() {
+		defer close(s.done)
+		defer release()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-tick:
+				m.DeleteExpired()
+			}
+		}
+	}()
+}
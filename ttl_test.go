@@ -0,0 +1,422 @@
+package shardmap
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTTL(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	m := NewOptions(&Options{Clock: clock})
+	m.SetTTL("a", 1, time.Millisecond*10)
+	m.SetTTL("b", 2, time.Hour)
+	m.SetTTL("c", 3, time.Millisecond*10)
+	m.Set("c", 3) // removes the expiration
+	m.SetTTL("d", 4, time.Millisecond*10)
+	if v, ok := m.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, v)
+	}
+	if !m.Touch("d", time.Hour) {
+		t.Fatal("expected true")
+	}
+	if m.Touch("missing", time.Hour) {
+		t.Fatal("expected false")
+	}
+	clock.Add(time.Millisecond * 9)
+	if v, ok := m.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, v)
+	}
+	clock.Add(time.Millisecond)
+	if _, ok := m.Get("a"); ok {
+		t.Fatal("expected false")
+	}
+	if _, ok := m.GetEx("a", time.Hour); ok {
+		t.Fatal("expected false")
+	}
+	for _, key := range []string{"b", "c", "d"} {
+		if _, ok := m.Get(key); !ok {
+			t.Fatalf("expected '%v' to exist", key)
+		}
+	}
+	m.SetTTL("e", 5, time.Millisecond)
+	clock.Add(time.Millisecond)
+	if _, replaced := m.Set("e", 6); replaced {
+		t.Fatal("expected false")
+	}
+	if v, ok := m.GetEx("e", time.Millisecond); !ok || v != 6 {
+		t.Fatalf("expected '%v', got '%v'", 6, v)
+	}
+	clock.Add(time.Millisecond)
+	if _, deleted := m.Delete("e"); deleted {
+		t.Fatal("expected false")
+	}
+	m.SetTTL("f", 7, time.Millisecond)
+	m.SetTTL("g", 8, time.Millisecond)
+	clock.Add(time.Millisecond)
+	if n := m.DeleteExpired(); n != 2 {
+		t.Fatalf("expected '%v', got '%v'", 2, n)
+	}
+	if m.Len() != 3 {
+		t.Fatalf("expected '%v', got '%v'", 3, m.Len())
+	}
+}
+
+func TestSweeper(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	m := NewOptions(&Options{Clock: clock})
+	tick := make(chan time.Time)
+	var released bool
+	m.sweepOn(tick, func() { released = true })
+	for i := 0; i < 100; i++ {
+		m.SetTTL(k(i), i, time.Minute)
+	}
+	m.Set("keep", true)
+	tick <- time.Time{}
+	tick <- time.Time{} // waits for the first sweep to finish
+	if m.LenExpired() != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, m.LenExpired())
+	}
+	if m.Len() != 101 {
+		t.Fatalf("expected '%v', got '%v'", 101, m.Len())
+	}
+	clock.Add(time.Minute)
+	if m.LenExpired() != 100 {
+		t.Fatalf("expected '%v', got '%v'", 100, m.LenExpired())
+	}
+	tick <- time.Time{}
+	close(m.sweep.stop)
+	<-m.sweep.done
+	if m.Len() != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, m.Len())
+	}
+	if m.LenExpired() != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, m.LenExpired())
+	}
+	if !released {
+		t.Fatal("expected the ticker to be released")
+	}
+
+	m = NewOptions(&Options{SweepInterval: time.Hour})
+	m.Len()
+	if m.sweep == nil {
+		t.Fatal("expected a sweeper")
+	}
+	m.Close()
+}
+
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func TestClock(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	var expired []string
+	m := NewOptions(&Options{
+		Clock:     clock,
+		TrackMeta: true,
+		OnExpire: func(key string, value interface{}) {
+			expired = append(expired, key)
+		},
+	})
+	m.SetTTL("a", 1, time.Minute)
+	m.SetTTL("b", 2, time.Hour)
+	m.SetTTL("c", 3, time.Minute)
+	if meta, _ := m.GetMeta("a"); !meta.Created.Equal(time.Unix(1000, 0)) {
+		t.Fatalf("expected '%v', got '%v'", time.Unix(1000, 0), meta.Created)
+	}
+	m.Delete("c")
+	clock.Add(time.Minute)
+	if _, ok := m.Get("a"); ok {
+		t.Fatal("expected false")
+	}
+	if _, ok := m.Get("b"); !ok {
+		t.Fatal("expected true")
+	}
+	if len(expired) != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, len(expired))
+	}
+	m.DeleteExpired()
+	if len(expired) != 1 || expired[0] != "a" {
+		t.Fatalf("expected '%v', got '%v'", []string{"a"}, expired)
+	}
+	clock.Add(time.Hour)
+	m.Set("b", 3)
+	if len(expired) != 2 || expired[1] != "b" {
+		t.Fatalf("expected '%v', got '%v'", []string{"a", "b"}, expired)
+	}
+}
+
+func TestLenExpired(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	m := NewOptions(&Options{Clock: clock})
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			m.SetTTL(k(i), i, time.Minute)
+		} else {
+			m.Set(k(i), i)
+		}
+	}
+	m.SetTTL("pinned", true, time.Minute)
+	m.Pin("pinned")
+	if m.Len() != 101 || m.LenExpired() != 0 {
+		t.Fatalf("expected '%v/%v', got '%v/%v'", 101, 0, m.Len(), m.LenExpired())
+	}
+	clock.Add(time.Minute)
+	if m.Len() != 51 || m.LenExpired() != 50 {
+		t.Fatalf("expected '%v/%v', got '%v/%v'", 51, 50, m.Len(), m.LenExpired())
+	}
+	var n int
+	m.Range(func(key string, value interface{}) bool {
+		if key != "pinned" && value.(int)%2 == 0 {
+			t.Fatalf("unexpected key '%v'", key)
+		}
+		n++
+		return true
+	})
+	if n != 51 {
+		t.Fatalf("expected '%v', got '%v'", 51, n)
+	}
+	if len(m.ToMap()) != 51 {
+		t.Fatalf("expected '%v', got '%v'", 51, len(m.ToMap()))
+	}
+	m.DeleteExpired()
+	if m.Len() != 51 || m.LenExpired() != 0 {
+		t.Fatalf("expected '%v/%v', got '%v/%v'", 51, 0, m.Len(), m.LenExpired())
+	}
+}
+
+func TestExpiredScans(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		clock := &testClock{now: time.Unix(1000, 0)}
+		var log bytes.Buffer
+		m := NewOptions(&Options{Clock: clock, IndexKeys: indexed, Log: &log})
+		live := New(0)
+		for i := 0; i < 100; i++ {
+			if i%2 == 0 {
+				m.SetTTL(k(i), k(i), time.Minute)
+			} else {
+				m.Set(k(i), k(i))
+				live.Set(k(i), k(i))
+			}
+		}
+		m.SetTTL("ttl", "ttl", time.Hour)
+		live.Set("ttl", "ttl")
+		view := m.ReadView()
+		clock.Add(time.Minute)
+		// every entry that's visited must be live
+		check := func(name string, key string) {
+			t.Helper()
+			if _, ok := live.Get(key); !ok {
+				t.Fatalf("%s: unexpected key '%v'", name, key)
+			}
+		}
+		type scanner func(iter func(key string, value interface{}) bool)
+		count := func(name string, scan scanner) {
+			t.Helper()
+			var n int
+			scan(func(key string, value interface{}) bool {
+				check(name, key)
+				n++
+				return true
+			})
+			if n != 51 {
+				t.Fatalf("%s: expected '%v', got '%v'", name, 51, n)
+			}
+		}
+		count("Range", m.Range)
+		count("RangeSnapshot", m.RangeSnapshot)
+		count("RangeSorted", m.RangeSorted)
+		count("RangePrefix", func(iter func(key string, value interface{}) bool) {
+			m.RangePrefix("", iter)
+		})
+		count("Stream", func(iter func(key string, value interface{}) bool) {
+			for e := range m.Stream(0) {
+				iter(e.Key, e.Value)
+			}
+		})
+		count("Page", func(iter func(key string, value interface{}) bool) {
+			var token string
+			for {
+				var entries []Entry
+				entries, token = m.Page(token, 7)
+				for _, e := range entries {
+					iter(e.Key, e.Value)
+				}
+				if token == "" {
+					break
+				}
+			}
+		})
+		count("Sample", func(iter func(key string, value interface{}) bool) {
+			for _, e := range m.Sample(100) {
+				iter(e.Key, e.Value)
+			}
+		})
+		for _, e := range m.Sample(10) {
+			check("Sample", e.Key)
+		}
+		count("Filter", m.Filter(func(string, interface{}) bool {
+			return true
+		}).Range)
+		count("ReadView", m.ReadView().Range)
+		count("old ReadView", view.Range)
+		if _, ok := view.Get(k(0)); ok || view.Len() != 51 {
+			t.Fatalf("expected '%v', got '%v'", 51, view.Len())
+		}
+		all := func(string, interface{}) bool { return true }
+		if n := m.Count(all); n != 51 {
+			t.Fatalf("expected '%v', got '%v'", 51, n)
+		}
+		keys := m.FindKeys(func(interface{}) bool { return true }, 0)
+		if len(keys) != 51 {
+			t.Fatalf("expected '%v', got '%v'", 51, len(keys))
+		}
+		n := m.Reduce(func(acc interface{}, _ string, _ interface{}) interface{} {
+			return acc.(int) + 1
+		}, func(a, b interface{}) interface{} {
+			return a.(int) + b.(int)
+		}, 0)
+		if n != 51 {
+			t.Fatalf("expected '%v', got '%v'", 51, n)
+		}
+		if !m.Equal(live, nil) || !live.Equal(m, nil) {
+			t.Fatal("expected true")
+		}
+		// saved and logged entries keep their TTL
+		var saved, compacted bytes.Buffer
+		if err := m.Save(&saved); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Compact(&compacted); err != nil {
+			t.Fatal(err)
+		}
+		for name, r := range map[string]*bytes.Buffer{
+			"Save": &saved, "Compact": &compacted, "Log": &log,
+		} {
+			m2 := NewOptions(&Options{Clock: clock})
+			if err := m2.LoadFromLog(bytes.NewReader(r.Bytes())); err != nil {
+				t.Fatal(err)
+			}
+			if m2.Len() != 51 {
+				t.Fatalf("%s: expected '%v', got '%v'", name, 51, m2.Len())
+			}
+			clock.Add(time.Hour)
+			if m2.Len() != 50 {
+				t.Fatalf("%s: expected '%v', got '%v'", name, 50, m2.Len())
+			}
+			clock.Add(-time.Hour)
+		}
+		var drained int
+		m.Drain(func(key string, value interface{}) {
+			check("Drain", key)
+			drained++
+		})
+		if drained != 51 {
+			t.Fatalf("expected '%v', got '%v'", 51, drained)
+		}
+	}
+}
+
+func TestExpiredPopAny(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	m := NewOptions(&Options{Clock: clock, Shards: 4})
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			m.SetTTL(k(i), i, time.Minute)
+		} else {
+			m.Set(k(i), i)
+		}
+	}
+	clock.Add(time.Minute)
+	var n int
+	for {
+		_, value, ok := m.PopAny()
+		if !ok {
+			break
+		}
+		if value.(int)%2 == 0 {
+			t.Fatalf("unexpected value '%v'", value)
+		}
+		n++
+	}
+	if n != 50 {
+		t.Fatalf("expected '%v', got '%v'", 50, n)
+	}
+}
+
+func TestExpiredAccept(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	m := NewOptions(&Options{Clock: clock, TrackMeta: true})
+	for _, key := range []string{"a", "b", "c", "d"} {
+		m.SetTTL(key, key, time.Minute)
+	}
+	clock.Add(time.Minute)
+	check := func(prev interface{}, replaced bool) bool {
+		if replaced || prev != nil {
+			t.Fatalf("expected '%v', got '%v'", nil, prev)
+		}
+		return true
+	}
+	m.SetAccept("a", 1, check)
+	m.DeleteAccept("b", check)
+	m.SetAcceptMeta("c", 1, func(prev interface{}, _ Meta, ok bool) bool {
+		return check(prev, ok)
+	})
+	m.DeleteAcceptMeta("d", func(prev interface{}, _ Meta, ok bool) bool {
+		return check(prev, ok)
+	})
+	if m.Len() != 2 || m.LenExpired() != 0 {
+		t.Fatalf("expected '%v/%v', got '%v/%v'", 2, 0, m.Len(), m.LenExpired())
+	}
+}
+
+func TestTTLLeft(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	m := NewOptions(&Options{
+		Clock:                clock,
+		StaleWhileRevalidate: time.Minute,
+	})
+	m.SetTTL("a", 1, time.Minute)
+	m.Set("b", 2)
+	m.SetTTL("c", 3, time.Minute)
+	m.Pin("c")
+	if ttl, ok := m.TTL("a"); !ok || ttl != time.Minute {
+		t.Fatalf("expected '%v', got '%v'", time.Minute, ttl)
+	}
+	if ttl, ok := m.TTL("b"); !ok || ttl >= 0 {
+		t.Fatalf("expected a negative ttl, got '%v'", ttl)
+	}
+	if ttl, ok := m.TTL("c"); !ok || ttl >= 0 {
+		t.Fatalf("expected a negative ttl, got '%v'", ttl)
+	}
+	if _, ok := m.TTL("missing"); ok {
+		t.Fatal("expected false")
+	}
+	clock.Add(time.Second * 20)
+	if ttl, _ := m.TTL("a"); ttl != time.Second*40 {
+		t.Fatalf("expected '%v', got '%v'", time.Second*40, ttl)
+	}
+	clock.Add(time.Minute) // stale
+	if ttl, ok := m.TTL("a"); !ok || ttl != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, ttl)
+	}
+	clock.Add(time.Minute)
+	if _, ok := m.TTL("a"); ok {
+		t.Fatal("expected false")
+	}
+}
@@ -0,0 +1,64 @@
+package shardmap
+
+// GetString returns the string value for a key.
+// Returns false when no value has been assign for key, or when the value is
+// not a string.
+func (m *Map) GetString(key string) (value string, ok bool) {
+	v, _ := m.Get(key)
+	value, ok = v.(string)
+	return value, ok
+}
+
+// GetBytes returns the []byte value for a key.
+// Returns false when no value has been assign for key, or when the value is
+// not a []byte.
+func (m *Map) GetBytes(key string) (value []byte, ok bool) {
+	v, _ := m.Get(key)
+	value, ok = v.([]byte)
+	return value, ok
+}
+
+// GetInt returns the int value for a key.
+// Returns false when no value has been assign for key, or when the value is
+// not an int.
+func (m *Map) GetInt(key string) (value int, ok bool) {
+	v, _ := m.Get(key)
+	value, ok = v.(int)
+	return value, ok
+}
+
+// GetInt64 returns the int64 value for a key.
+// Returns false when no value has been assign for key, or when the value is
+// not an int64.
+func (m *Map) GetInt64(key string) (value int64, ok bool) {
+	v, _ := m.Get(key)
+	value, ok = v.(int64)
+	return value, ok
+}
+
+// GetUint64 returns the uint64 value for a key.
+// Returns false when no value has been assign for key, or when the value is
+// not a uint64.
+func (m *Map) GetUint64(key string) (value uint64, ok bool) {
+	v, _ := m.Get(key)
+	value, ok = v.(uint64)
+	return value, ok
+}
+
+// GetFloat64 returns the float64 value for a key.
+// Returns false when no value has been assign for key, or when the value is
+// not a float64.
+func (m *Map) GetFloat64(key string) (value float64, ok bool) {
+	v, _ := m.Get(key)
+	value, ok = v.(float64)
+	return value, ok
+}
+
+// GetBool returns the bool value for a key.
+// Returns false when no value has been assign for key, or when the value is
+// not a bool.
+func (m *Map) GetBool(key string) (value bool, ok bool) {
+	v, _ := m.Get(key)
+	value, ok = v.(bool)
+	return value, ok
+}
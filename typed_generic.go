@@ -0,0 +1,13 @@
+//go:build go1.18
+// +build go1.18
+
+package shardmap
+
+// GetAs returns the value for a key as type T.
+// Returns the zero value of T and false when no value has been assign for
+// key, or when the value is not a T.
+func GetAs[T any](m *Map, key string) (value T, ok bool) {
+	v, _ := m.Get(key)
+	value, ok = v.(T)
+	return value, ok
+}
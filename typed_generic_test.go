@@ -0,0 +1,22 @@
+//go:build go1.18
+// +build go1.18
+
+package shardmap
+
+import "testing"
+
+func TestGetAs(t *testing.T) {
+	type point struct{ X, Y int }
+	var m Map
+	m.Set("point", point{1, 2})
+	m.Set("int", 1)
+	if v, ok := GetAs[point](&m, "point"); !ok || v != (point{1, 2}) {
+		t.Fatalf("expected '%v', got '%v'", point{1, 2}, v)
+	}
+	if v, ok := GetAs[point](&m, "int"); ok || v != (point{}) {
+		t.Fatalf("expected '%v', got '%v'", point{}, v)
+	}
+	if v, ok := GetAs[int](&m, "missing"); ok || v != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, v)
+	}
+}
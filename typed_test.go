@@ -0,0 +1,46 @@
+package shardmap
+
+import "testing"
+
+func TestTypedGetters(t *testing.T) {
+	var m Map
+	m.Set("string", "hello")
+	m.Set("bytes", []byte("hello"))
+	m.Set("int", 1)
+	m.Set("int64", int64(2))
+	m.Set("uint64", uint64(3))
+	m.Set("float64", 4.5)
+	m.Set("bool", true)
+	if v, ok := m.GetString("string"); !ok || v != "hello" {
+		t.Fatalf("expected '%v', got '%v'", "hello", v)
+	}
+	if v, ok := m.GetBytes("bytes"); !ok || string(v) != "hello" {
+		t.Fatalf("expected '%v', got '%v'", "hello", v)
+	}
+	if v, ok := m.GetInt("int"); !ok || v != 1 {
+		t.Fatalf("expected '%v', got '%v'", 1, v)
+	}
+	if v, ok := m.GetInt64("int64"); !ok || v != 2 {
+		t.Fatalf("expected '%v', got '%v'", 2, v)
+	}
+	if v, ok := m.GetUint64("uint64"); !ok || v != 3 {
+		t.Fatalf("expected '%v', got '%v'", 3, v)
+	}
+	if v, ok := m.GetFloat64("float64"); !ok || v != 4.5 {
+		t.Fatalf("expected '%v', got '%v'", 4.5, v)
+	}
+	if v, ok := m.GetBool("bool"); !ok || !v {
+		t.Fatalf("expected '%v', got '%v'", true, v)
+	}
+	// type mismatch
+	if v, ok := m.GetString("int"); ok || v != "" {
+		t.Fatalf("expected '%v', got '%v'", "", v)
+	}
+	if v, ok := m.GetInt64("int"); ok || v != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, v)
+	}
+	// missing
+	if v, ok := m.GetBytes("missing"); ok || v != nil {
+		t.Fatalf("expected '%v', got '%v'", nil, v)
+	}
+}
@@ -0,0 +1,176 @@
+package shardmap
+
+import "sync"
+
+// Uint64Map is a hashmap with uint64 keys. Like map[uint64]interface{}, but
+// sharded and thread-safe. Keys are hashed directly, which avoids the
+// formatting and allocation needed to use integers as Map keys.
+type Uint64Map struct {
+	init   sync.Once
+	cap    int
+	shards int
+	mus    []sync.RWMutex
+	maps   []map[uint64]interface{}
+}
+
+// NewUint64 returns a new hashmap with the specified capacity. This function
+// is only needed when you must define a minimum capacity, otherwise just use:
+//    var m shardmap.Uint64Map
+func NewUint64(cap int) *Uint64Map {
+	return &Uint64Map{cap: cap}
+}
+
+// Clear out all values from map
+func (m *Uint64Map) Clear() {
+	m.initDo()
+	for i := 0; i < m.shards; i++ {
+		m.mus[i].Lock()
+		m.maps[i] = make(map[uint64]interface{}, shardCap(m.cap, m.shards))
+		m.mus[i].Unlock()
+	}
+}
+
+// Set assigns a value to a key.
+// Returns the previous value, or false when no value was assigned.
+func (m *Uint64Map) Set(key uint64, value interface{}) (
+	prev interface{}, replaced bool,
+) {
+	m.initDo()
+	shard := m.choose(key)
+	m.mus[shard].Lock()
+	prev, replaced = m.maps[shard][key]
+	m.maps[shard][key] = value
+	m.mus[shard].Unlock()
+	return prev, replaced
+}
+
+// SetAccept assigns a value to a key. The "accept" function can be used to
+// inspect the previous value, if any, and accept or reject the change.
+// It's also provides a safe way to block other others from writing to the
+// same shard while inspecting.
+// Returns the previous value, or false when no value was assigned.
+func (m *Uint64Map) SetAccept(
+	key uint64, value interface{},
+	accept func(prev interface{}, replaced bool) bool,
+) (prev interface{}, replaced bool) {
+	m.initDo()
+	shard := m.choose(key)
+	m.mus[shard].Lock()
+	defer m.mus[shard].Unlock()
+	prev, replaced = m.maps[shard][key]
+	if accept != nil && !accept(prev, replaced) {
+		return nil, false
+	}
+	m.maps[shard][key] = value
+	return prev, replaced
+}
+
+// Get returns a value for a key.
+// Returns false when no value has been assign for key.
+func (m *Uint64Map) Get(key uint64) (value interface{}, ok bool) {
+	m.initDo()
+	shard := m.choose(key)
+	m.mus[shard].RLock()
+	value, ok = m.maps[shard][key]
+	m.mus[shard].RUnlock()
+	return value, ok
+}
+
+// Delete deletes a value for a key.
+// Returns the deleted value, or false when no value was assigned.
+func (m *Uint64Map) Delete(key uint64) (prev interface{}, deleted bool) {
+	m.initDo()
+	shard := m.choose(key)
+	m.mus[shard].Lock()
+	prev, deleted = m.maps[shard][key]
+	if deleted {
+		delete(m.maps[shard], key)
+	}
+	m.mus[shard].Unlock()
+	return prev, deleted
+}
+
+// DeleteAccept deletes a value for a key. The "accept" function can be used to
+// inspect the previous value, if any, and accept or reject the change.
+// It's also provides a safe way to block other others from writing to the
+// same shard while inspecting.
+// Returns the deleted value, or false when no value was assigned.
+func (m *Uint64Map) DeleteAccept(
+	key uint64,
+	accept func(prev interface{}, replaced bool) bool,
+) (prev interface{}, deleted bool) {
+	m.initDo()
+	shard := m.choose(key)
+	m.mus[shard].Lock()
+	defer m.mus[shard].Unlock()
+	prev, deleted = m.maps[shard][key]
+	if accept != nil && !accept(prev, deleted) {
+		return nil, false
+	}
+	if deleted {
+		delete(m.maps[shard], key)
+	}
+	return prev, deleted
+}
+
+// Len returns the number of values in map.
+func (m *Uint64Map) Len() int {
+	m.initDo()
+	var n int
+	for i := 0; i < m.shards; i++ {
+		m.mus[i].Lock()
+		n += len(m.maps[i])
+		m.mus[i].Unlock()
+	}
+	return n
+}
+
+// Range iterates overall all key/values.
+// It's not safe to call or Set or Delete while ranging.
+func (m *Uint64Map) Range(iter func(key uint64, value interface{}) bool) {
+	m.initDo()
+	var done bool
+	for i := 0; i < m.shards; i++ {
+		func() {
+			m.mus[i].RLock()
+			defer m.mus[i].RUnlock()
+			for key, value := range m.maps[i] {
+				if !iter(key, value) {
+					done = true
+					break
+				}
+			}
+		}()
+		if done {
+			break
+		}
+	}
+}
+
+func (m *Uint64Map) choose(key uint64) int {
+	return int(mix64(key) & uint64(m.shards-1))
+}
+
+func (m *Uint64Map) initDo() {
+	m.init.Do(func() {
+		m.shards = numShards()
+		scap := shardCap(m.cap, m.shards)
+		m.mus = make([]sync.RWMutex, m.shards)
+		m.maps = make([]map[uint64]interface{}, m.shards)
+		for i := 0; i < len(m.maps); i++ {
+			m.maps[i] = make(map[uint64]interface{}, scap)
+		}
+	})
+}
+
+// mix64 is the murmur3 finalizer. It scrambles the bits of sequential or
+// otherwise clustered keys, such as snowflake ids, so that they spread evenly
+// over the shards.
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,96 @@
+package shardmap
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestUint64Map(t *testing.T) {
+	N := 10000
+	keys := make([]uint64, N)
+	for i, x := range rand.Perm(N) {
+		keys[i] = uint64(x) << 22 // snowflake-like, low bits unset
+	}
+	for _, m := range []*Uint64Map{new(Uint64Map), NewUint64(N)} {
+		for i, key := range keys {
+			if v, ok := m.Set(key, i); ok || v != nil {
+				t.Fatalf("expected %v, got %v", nil, v)
+			}
+		}
+		if m.Len() != N {
+			t.Fatalf("expected %v, got %v", N, m.Len())
+		}
+		for i, key := range keys {
+			if v, ok := m.Get(key); !ok || v != i {
+				t.Fatalf("expected %v, got %v", i, v)
+			}
+		}
+		for i, key := range keys {
+			if v, ok := m.Set(key, i+1); !ok || v != i {
+				t.Fatalf("expected %v, got %v", i, v)
+			}
+		}
+		var n int
+		m.Range(func(key uint64, value interface{}) bool {
+			n++
+			return true
+		})
+		if n != N {
+			t.Fatalf("expected %v, got %v", N, n)
+		}
+		for i, key := range keys[:N/2] {
+			if v, ok := m.Delete(key); !ok || v != i+1 {
+				t.Fatalf("expected %v, got %v", i+1, v)
+			}
+			if v, ok := m.Delete(key); ok || v != nil {
+				t.Fatalf("expected %v, got %v", nil, v)
+			}
+		}
+		if m.Len() != N/2 {
+			t.Fatalf("expected %v, got %v", N/2, m.Len())
+		}
+		m.Clear()
+		if m.Len() != 0 {
+			t.Fatalf("expected %v, got %v", 0, m.Len())
+		}
+	}
+}
+
+func TestUint64MapAccept(t *testing.T) {
+	var m Uint64Map
+	m.Set(1, "world")
+	prev, replaced := m.SetAccept(1, "planet", func(prev interface{}, replaced bool) bool {
+		if !replaced || prev.(string) != "world" {
+			t.Fatalf("expected '%v', got '%v'", "world", prev)
+		}
+		return false
+	})
+	if replaced || prev != nil {
+		t.Fatalf("expected '%v', got '%v'", nil, prev)
+	}
+	if v, _ := m.Get(1); v.(string) != "world" {
+		t.Fatalf("expected '%v', got '%v'", "world", v)
+	}
+	prev, replaced = m.SetAccept(2, "planet", func(prev interface{}, replaced bool) bool {
+		return false
+	})
+	if replaced || prev != nil {
+		t.Fatalf("expected '%v', got '%v'", nil, prev)
+	}
+	if _, ok := m.Get(2); ok {
+		t.Fatal("expected false")
+	}
+	prev, deleted := m.DeleteAccept(1, func(prev interface{}, deleted bool) bool {
+		return false
+	})
+	if deleted || prev != nil {
+		t.Fatalf("expected '%v', got '%v'", nil, prev)
+	}
+	prev, deleted = m.DeleteAccept(1, nil)
+	if !deleted || prev.(string) != "world" {
+		t.Fatalf("expected '%v', got '%v'", "world", prev)
+	}
+	if m.Len() != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, m.Len())
+	}
+}
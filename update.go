@@ -0,0 +1,52 @@
+package shardmap
+
+// Op is what RangeUpdate does with an entry.
+type Op int
+
+const (
+	// OpKeep leaves the entry as is.
+	OpKeep Op = iota
+	// OpReplace assigns the new value to the entry.
+	OpReplace
+	// OpDelete deletes the entry.
+	OpDelete
+)
+
+// RangeUpdate iterates over all key/values, like Range, and the "iter"
+// function returns what to do with each of them. The changes to each shard
+// are applied while it's still write locked from iterating, thus no other
+// writes can get in between, and the "iter" function must not access the map.
+// Replaced entries keep their expiration and cost, if any, and expired
+// entries are not visited.
+// Returns the number of entries that were replaced or deleted.
+func (m *Map) RangeUpdate(
+	iter func(key string, value interface{}) (newValue interface{}, op Op),
+) int {
+	m.initDo()
+	var n int
+	var changes []batchOp
+	for i := 0; i < m.shards; i++ {
+		t := m.lock(i)
+		changes = changes[:0]
+		m.rangeShard(i, func(key string, value interface{}) bool {
+			switch value, op := iter(key, value); op {
+			case OpReplace:
+				changes = append(changes, batchOp{key: key, value: value})
+			case OpDelete:
+				changes = append(changes, batchOp{key: key, delete: true})
+			}
+			return true
+		})
+		// the shard map can't be changed while ranging over it
+		for _, op := range changes {
+			if op.delete {
+				m.delete(i, op.key)
+				continue
+			}
+			m.replace(i, op.key, op.value)
+		}
+		n += len(changes)
+		m.unlockOp(i, t, "RangeUpdate", "")
+	}
+	return n
+}
@@ -0,0 +1,78 @@
+package shardmap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRangeUpdate(t *testing.T) {
+	m := NewOptions(&Options{IndexKeys: true})
+	for i := 0; i < 1000; i++ {
+		m.Set(k(i), i)
+	}
+	m.SetTTL("ttl", 3, time.Hour)
+	n := m.RangeUpdate(func(key string, value interface{}) (interface{}, Op) {
+		switch value.(int) % 3 {
+		case 0:
+			return value.(int) * 2, OpReplace
+		case 1:
+			return nil, OpDelete
+		}
+		return nil, OpKeep
+	})
+	if n != 668 {
+		t.Fatalf("expected '%v', got '%v'", 668, n)
+	}
+	if m.Len() != 668 {
+		t.Fatalf("expected '%v', got '%v'", 668, m.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		v, ok := m.Get(k(i))
+		switch i % 3 {
+		case 0:
+			if v != i*2 {
+				t.Fatalf("expected '%v', got '%v'", i*2, v)
+			}
+		case 1:
+			if ok {
+				t.Fatalf("expected '%v', got '%v'", nil, v)
+			}
+		case 2:
+			if v != i {
+				t.Fatalf("expected '%v', got '%v'", i, v)
+			}
+		}
+	}
+	if v, _ := m.Get("ttl"); v != 6 {
+		t.Fatalf("expected '%v', got '%v'", 6, v)
+	}
+	if _, ok := m.expires[m.choose("ttl")]["ttl"]; !ok {
+		t.Fatal("expected the expiration to be kept")
+	}
+	var keys int
+	m.RangePrefix("", func(key string, value interface{}) bool {
+		keys++
+		return true
+	})
+	if keys != 668 {
+		t.Fatalf("expected '%v', got '%v'", 668, keys)
+	}
+}
+
+func TestRangeUpdateCost(t *testing.T) {
+	m := NewOptions(&Options{MaxCost: 100})
+	m.SetWithCost("a", 1, 60)
+	m.Set("b", 2)
+	m.RangeUpdate(func(key string, value interface{}) (interface{}, Op) {
+		return value.(int) * 10, OpReplace
+	})
+	if v, _ := m.Get("a"); v != 10 {
+		t.Fatalf("expected '%v', got '%v'", 10, v)
+	}
+	if m.Cost() != 60 {
+		t.Fatalf("expected '%v', got '%v'", 60, m.Cost())
+	}
+	if _, _, ok := m.SetWithCost("c", 3, 60); ok {
+		t.Fatal("expected the kept cost to count against MaxCost")
+	}
+}
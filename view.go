@@ -0,0 +1,139 @@
+package shardmap
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ReadView is an immutable copy of a Map, returned by ReadView. Reads do not
+// take any locks, and it's safe to use from multiple goroutines.
+type ReadView struct {
+	created time.Time
+	choose  func(key string) int // the shard of a key, like Map.choose
+	now     func() int64         // like Map.now
+	vers    []uint64
+	maps    []map[string]interface{}
+	expires []map[string]int64 // when the keys with a TTL expire
+}
+
+// Get returns a value for a key. Entries that expire after the view was
+// created are no longer returned once they expire.
+// Returns false when no value has been assign for key.
+func (v *ReadView) Get(key string) (value interface{}, ok bool) {
+	shard := v.choose(key)
+	value, ok = v.maps[shard][key]
+	if ok && len(v.expires[shard]) > 0 {
+		if at, has := v.expires[shard][key]; has && at <= v.now() {
+			return nil, false
+		}
+	}
+	return value, ok
+}
+
+// Len returns the number of values in the view.
+func (v *ReadView) Len() int {
+	var n int
+	var now int64
+	for i, m := range v.maps {
+		n += len(m)
+		if len(v.expires[i]) > 0 {
+			if now == 0 {
+				now = v.now()
+			}
+			for _, at := range v.expires[i] {
+				if at <= now {
+					n--
+				}
+			}
+		}
+	}
+	return n
+}
+
+// Range iterates overall all key/values.
+func (v *ReadView) Range(iter func(key string, value interface{}) bool) {
+	now := v.now()
+	for i, m := range v.maps {
+		for key, value := range m {
+			if at, ok := v.expires[i][key]; ok && at <= now {
+				continue
+			}
+			if !iter(key, value) {
+				return
+			}
+		}
+	}
+}
+
+// Created returns the time that the view was created.
+func (v *ReadView) Created() time.Time {
+	return v.created
+}
+
+// ReadView returns an immutable view of the map, meant for read-mostly maps
+// where even the shard read locks are measurable. The view is created on the
+// first call, and is then shared by all callers until it's refreshed, either
+// by RefreshView or, when Options.ViewStaleness is set, automatically once
+// it's older than the staleness interval. Writes to the map are not visible
+// in the view until then.
+func (m *Map) ReadView() *ReadView {
+	m.initDo()
+	v, _ := m.view.Load().(*ReadView)
+	if v == nil {
+		return m.RefreshView()
+	}
+	if m.stale > 0 && time.Since(v.created) > m.stale {
+		// only one caller refreshes, the others keep using the stale view
+		if atomic.CompareAndSwapInt32(&m.viewBusy, 0, 1) {
+			v = m.RefreshView()
+			atomic.StoreInt32(&m.viewBusy, 0)
+		}
+	}
+	return v
+}
+
+// RefreshView replaces the view returned by ReadView with a new copy of the
+// map, and returns it. Only the shards that changed since the previous view
+// are copied, the others are shared with it.
+func (m *Map) RefreshView() *ReadView {
+	m.initDo()
+	m.viewMu.Lock()
+	defer m.viewMu.Unlock()
+	old, _ := m.view.Load().(*ReadView)
+	v := &ReadView{
+		created: time.Now(),
+		choose:  m.choose,
+		now:     m.now,
+		vers:    make([]uint64, m.shards),
+		maps:    make([]map[string]interface{}, m.shards),
+		expires: make([]map[string]int64, m.shards),
+	}
+	for i := 0; i < m.shards; i++ {
+		t := m.rlock(i)
+		v.vers[i] = m.vers[i]
+		if len(m.expires[i]) == 0 && old != nil && old.vers[i] == m.vers[i] &&
+			len(old.expires[i]) == 0 {
+			v.maps[i] = old.maps[i]
+		} else {
+			// shards with a TTL are always copied, because expirations
+			// change without a new version
+			copy := make(map[string]interface{}, m.maps[i].Len())
+			m.rangeShard(i, func(key string, value interface{}) bool {
+				copy[key] = value
+				return true
+			})
+			v.maps[i] = copy
+			for key, e := range m.expires[i] {
+				if _, ok := copy[key]; ok && !m.pins[i][key] {
+					if v.expires[i] == nil {
+						v.expires[i] = make(map[string]int64)
+					}
+					v.expires[i][key] = e.at + int64(m.grace)
+				}
+			}
+		}
+		m.runlock(i, t)
+	}
+	m.view.Store(v)
+	return v
+}
@@ -0,0 +1,90 @@
+package shardmap
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadView(t *testing.T) {
+	var m Map
+	for i := 0; i < 1000; i++ {
+		m.Set(k(i), i)
+	}
+	v := m.ReadView()
+	if v.Len() != 1000 {
+		t.Fatalf("expected '%v', got '%v'", 1000, v.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		if value, ok := v.Get(k(i)); !ok || value != i {
+			t.Fatalf("expected '%v', got '%v'", i, value)
+		}
+	}
+	m.Set(k(0), "changed")
+	m.Delete(k(1))
+	if m.ReadView() != v {
+		t.Fatal("expected the same view")
+	}
+	if value, _ := v.Get(k(0)); value != 0 {
+		t.Fatalf("expected '%v', got '%v'", 0, value)
+	}
+	nv := m.RefreshView()
+	if nv == v || m.ReadView() != nv {
+		t.Fatal("expected a new view")
+	}
+	if value, _ := nv.Get(k(0)); value != "changed" {
+		t.Fatalf("expected '%v', got '%v'", "changed", value)
+	}
+	if _, ok := nv.Get(k(1)); ok {
+		t.Fatal("expected false")
+	}
+	var shared int
+	for i := range v.maps {
+		if v.vers[i] == nv.vers[i] {
+			if reflect.ValueOf(v.maps[i]).Pointer() !=
+				reflect.ValueOf(nv.maps[i]).Pointer() {
+				t.Fatal("expected unchanged shards to be shared")
+			}
+			shared++
+		}
+	}
+	if shared == 0 {
+		t.Fatal("expected unchanged shards to be shared")
+	}
+	var n int
+	nv.Range(func(key string, value interface{}) bool {
+		n++
+		return true
+	})
+	if n != 999 {
+		t.Fatalf("expected '%v', got '%v'", 999, n)
+	}
+}
+
+func TestReadViewStaleness(t *testing.T) {
+	m := NewOptions(&Options{ViewStaleness: time.Millisecond * 10})
+	m.Set("hello", "world")
+	v := m.ReadView()
+	m.Set("hello", "planet")
+	time.Sleep(time.Millisecond * 20)
+	nv := m.ReadView()
+	if nv == v {
+		t.Fatal("expected a new view")
+	}
+	if value, _ := nv.Get("hello"); value != "planet" {
+		t.Fatalf("expected '%v', got '%v'", "planet", value)
+	}
+}
+
+func TestReadViewJumpHash(t *testing.T) {
+	m := NewOptions(&Options{JumpHash: true})
+	for i := 0; i < 1000; i++ {
+		m.Set(k(i), i)
+	}
+	v := m.ReadView()
+	for i := 0; i < 1000; i++ {
+		if value, ok := v.Get(k(i)); !ok || value != i {
+			t.Fatalf("expected '%v', got '%v'", i, value)
+		}
+	}
+}